@@ -0,0 +1,141 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"sync/atomic"
+
+	"gopkg.in/spacemonkeygo/monitor.v1/utils"
+)
+
+const (
+	// overflowPrefix starts the name of the monitors that new names are sent
+	// to once a cardinality limit is reached. There is one per kind of
+	// monitor, such as cardinality_overflow_task.
+	overflowPrefix = "cardinality_overflow_"
+)
+
+// SetCardinalityLimit caps how many distinct names the MonitorGroup holds
+// monitors and datapoint collectors under. A name with both, like a data
+// task's, counts once. Once the cap is reached, names that
+// don't exist yet are all recorded on a single overflow monitor per kind of
+// monitor (such as cardinality_overflow_event), the rejected names are
+// counted in the group's cardinality.rejected stat, and the first rejection
+// is logged. cardinality.rejected counts every time a new name was turned
+// away, so a name used many times is counted many times. This keeps an
+// instrumentation mistake, like putting a user id in a monitor name, from
+// using up all of the process's memory. A limit of zero, the default, means
+// no limit.
+func (self *MonitorGroup) SetCardinalityLimit(limit int) {
+	self.mtx.Lock()
+	self.cardinality_limit = limit
+	self.mtx.Unlock()
+}
+
+// SetCardinalityLimit caps how many distinct names all of the MonitorStore's
+// groups hold monitors and datapoint collectors under, in total. Once the cap is
+// reached, new names in every group are handled like in
+// MonitorGroup.SetCardinalityLimit. A limit of zero, the default, means no
+// limit.
+func (s *MonitorStore) SetCardinalityLimit(limit int) {
+	s.mtx.Lock()
+	s.cardinality_limit = limit
+	s.mtx.Unlock()
+}
+
+// size is the number of distinct names in the group.
+func (self *MonitorGroup) size() int {
+	self.names_mtx.Lock()
+	defer self.names_mtx.Unlock()
+	return self.names
+}
+
+// create finds or creates the value stored under name in cache, which is
+// either the group's monitors or its collectors, and reports whether it was
+// created. A name that's new to the group counts against the cardinality
+// limits, but a name that other, the remaining cache, already holds was
+// counted when it went in there.
+func (self *MonitorGroup) create(cache, other *utils.ThreadsafeCache,
+	name string, create func() interface{}) (val interface{}, created bool,
+	err error) {
+	self.names_mtx.Lock()
+	defer self.names_mtx.Unlock()
+	val, err = cache.Get(name, func(_ interface{}) (interface{}, error) {
+		created = true
+		return create(), nil
+	})
+	if created && !other.Contains(name) {
+		self.grew(1)
+	}
+	return val, created, err
+}
+
+// atCardinalityLimit returns whether adding another name would go over the
+// group's or its store's cardinality limit.
+func (self *MonitorGroup) atCardinalityLimit() bool {
+	self.mtx.Lock()
+	limit := self.cardinality_limit
+	store := self.store
+	self.mtx.Unlock()
+	if limit > 0 && self.size() >= limit {
+		return true
+	}
+	return store != nil && store.atCardinalityLimit()
+}
+
+func (s *MonitorStore) atCardinalityLimit() bool {
+	s.mtx.Lock()
+	limit := s.cardinality_limit
+	s.mtx.Unlock()
+	if limit <= 0 {
+		return false
+	}
+	return atomic.LoadInt64(&s.size) >= int64(limit)
+}
+
+// grew records that the group gained (or, if n is negative, lost) n names.
+// names_mtx must be held.
+func (self *MonitorGroup) grew(n int) {
+	self.names += n
+	self.mtx.Lock()
+	store := self.store
+	self.mtx.Unlock()
+	if store != nil && n != 0 {
+		atomic.AddInt64(&store.size, int64(n))
+	}
+}
+
+// rejectName records that name was turned away by a cardinality limit and
+// returns the overflow name to use for this kind of monitor instead.
+func (self *MonitorGroup) rejectName(name, kind string) string {
+	self.mtx.Lock()
+	self.cardinality_rejected += 1
+	first := self.cardinality_rejected == 1
+	self.mtx.Unlock()
+
+	if first {
+		logger.Errorf("monitor group %s hit its cardinality limit; new names "+
+			"such as %s are being recorded as %s%s", self.group_name, name,
+			overflowPrefix, kind)
+		self.Chain("cardinality", MonitorFunc(
+			func(cb func(name string, val float64)) {
+				self.mtx.Lock()
+				rejected := self.cardinality_rejected
+				self.mtx.Unlock()
+				cb("rejected", float64(rejected))
+			}))
+	}
+	return overflowPrefix + kind
+}
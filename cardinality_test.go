@@ -0,0 +1,103 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCardinalityLimit(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	mon.SetCardinalityLimit(2)
+	for i := 0; i < 5; i++ {
+		mon.EventNamed(fmt.Sprintf("user_%d", i))
+	}
+	// existing names keep working
+	mon.EventNamed("user_0")
+
+	stats := Collect(mon)
+	if stats["foo.user_0.count"] != 2 || stats["foo.user_1.count"] != 1 {
+		t.Errorf("existing monitors not kept: %v", stats)
+	}
+	if _, ok := stats["foo.user_2.count"]; ok {
+		t.Errorf("monitor created over the limit: %v", stats)
+	}
+	if stats["foo.cardinality_overflow_event.count"] != 3 {
+		t.Errorf("overflow not counted: %v", stats)
+	}
+	if stats["foo.cardinality.rejected"] != 3 {
+		t.Errorf("rejections not counted: %v", stats)
+	}
+}
+
+func TestStoreCardinalityLimit(t *testing.T) {
+	store := NewMonitorStore()
+	store.SetCardinalityLimit(2)
+	store.GetMonitorsNamed("a").Val("x", 1)
+	store.GetMonitorsNamed("b").Val("x", 1)
+	store.GetMonitorsNamed("b").Data("y", 1)
+
+	stats := Collect(store)
+	if stats["a.x.count"] != 1 || stats["b.x.count"] != 1 {
+		t.Errorf("existing monitors not kept: %v", stats)
+	}
+	if stats["b.cardinality.rejected"] != 1 {
+		t.Errorf("rejections not counted: %v", stats)
+	}
+	var names []string
+	store.Datapoints(false, func(name string, data [][]float64, total uint64,
+		clipped bool, fraction float64) {
+		names = append(names, name)
+	})
+	if len(names) != 1 || names[0] != "b.cardinality_overflow_data.data" {
+		t.Errorf("datapoint not sent to overflow collector: %v", names)
+	}
+
+	// removing monitors makes room under the limit again
+	store.RemoveGroup("b")
+	store.GetMonitorsNamed("a").Remove("x")
+	store.GetMonitorsNamed("a").Val("z", 1)
+	if stats := Collect(store); stats["a.z.count"] != 1 {
+		t.Errorf("no room made by removal: %v", stats)
+	}
+}
+
+func TestDataTaskCardinality(t *testing.T) {
+	store := NewMonitorStore()
+	store.SetCardinalityLimit(2)
+	mon := store.GetMonitorsNamed("foo")
+	mon.SetCardinalityLimit(2)
+	// a data task's monitor and collector share a name, so they count once
+	mon.DataTaskNamed("a")(nil)
+	mon.EventNamed("b")
+
+	stats := Collect(store)
+	if stats["foo.a.success"] != 1 || stats["foo.b.count"] != 1 {
+		t.Errorf("monitors under the limit not kept: %v", stats)
+	}
+	if _, ok := stats["foo.cardinality.rejected"]; ok {
+		t.Errorf("data task counted twice: %v", stats)
+	}
+
+	// removing the data task frees up its one name
+	mon.Remove("a")
+	mon.EventNamed("c")
+	if stats := Collect(store); stats["foo.c.count"] != 1 {
+		t.Errorf("no room made by removal: %v", stats)
+	}
+}
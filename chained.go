@@ -25,8 +25,9 @@ import (
 // ChainedMonitor is a monitor that simply wraps another monitor, while
 // allowing for atomic monitor changing.
 type ChainedMonitor struct {
-	mtx   sync.Mutex
-	other Monitor
+	mtx         sync.Mutex
+	other       Monitor
+	description string
 }
 
 // NewChainedMonitor returns a ChainedMonitor
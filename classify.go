@@ -0,0 +1,154 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/spacemonkeygo/errors"
+	"golang.org/x/net/context"
+)
+
+// ErrorClassifier maps an error a task failed with to the name of the bucket
+// it's counted in, reported as error_<name>. Names are sanitized and cut to
+// Config.MaxErrorLength afterwards, so they can be anything.
+type ErrorClassifier func(err error) string
+
+// genericErrorTypes are error types that say nothing about what went wrong.
+var genericErrorTypes = map[string]bool{
+	"*errors.errorString": true,
+	"*fmt.wrapError":      true,
+	"*fmt.wrapErrors":     true,
+}
+
+// DefaultErrorClassifier is the ErrorClassifier tasks use unless told
+// otherwise. Errors are unwrapped, following their Unwrap methods, until one
+// of them is from github.com/spacemonkeygo/errors with a class other than
+// SystemError (its class), a context error ("Canceled" or
+// "DeadlineExceeded"), a syscall.Errno (its message, like
+// "connection refused"), or a net.Error that timed out ("timeout"). Failing
+// that, the outermost error type that isn't a plain error from errors.New or
+// fmt.Errorf names the bucket, such as "net.DNSError". Errors that are all
+// plain are "System Error".
+func DefaultErrorClassifier(err error) string {
+	type_name := ""
+	for {
+		if class := errors.GetClass(err); class != errors.SystemError {
+			return class.String()
+		}
+		switch err {
+		case context.Canceled:
+			return "Canceled"
+		case context.DeadlineExceeded:
+			return "DeadlineExceeded"
+		}
+		switch e := err.(type) {
+		case syscall.Errno:
+			return e.Error()
+		case net.Error:
+			if e.Timeout() {
+				return "timeout"
+			}
+		}
+		if name := fmt.Sprintf("%T", err); type_name == "" &&
+			!genericErrorTypes[name] {
+			type_name = strings.TrimPrefix(name, "*")
+		}
+		next := unwrapError(err)
+		if next == nil {
+			break
+		}
+		err = next
+	}
+	if type_name == "" {
+		return errors.SystemError.String()
+	}
+	return type_name
+}
+
+// unwrapError returns the error err wraps, or nil if it doesn't wrap one.
+// Errors that wrap several unwrap to the first.
+func unwrapError(err error) error {
+	switch wrapper := err.(type) {
+	case interface {
+		Unwrap() error
+	}:
+		return wrapper.Unwrap()
+	case interface {
+		Unwrap() []error
+	}:
+		if errs := wrapper.Unwrap(); len(errs) > 0 {
+			return errs[0]
+		}
+	}
+	return nil
+}
+
+// SetErrorClassifier makes the TaskMonitor put failed tasks' errors in the
+// buckets classifier says. A nil classifier means DefaultErrorClassifier.
+func (t *TaskMonitor) SetErrorClassifier(classifier ErrorClassifier) {
+	t.mtx.Lock()
+	t.classifier = classifier
+	t.mtx.Unlock()
+}
+
+// classify returns the bucket err belongs in, cut and sanitized.
+func (t *TaskMonitor) classify(err error) string {
+	t.mtx.Lock()
+	classifier := t.classifier
+	t.mtx.Unlock()
+	if classifier == nil {
+		classifier = DefaultErrorClassifier
+	}
+	error_name := classifier(err)
+	if max_len := Config.MaxErrorLength; len(error_name) > max_len {
+		error_name = error_name[:max_len]
+	}
+	return SanitizeName(error_name)
+}
+
+// SetErrorClassifier calls SetErrorClassifier on every TaskMonitor in the
+// group, and on every TaskMonitor the group creates afterwards.
+func (self *MonitorGroup) SetErrorClassifier(classifier ErrorClassifier) {
+	self.mtx.Lock()
+	self.classifier = classifier
+	self.mtx.Unlock()
+	self.eachMonitor(func(name string, mon Monitor) {
+		if task_monitor, ok := mon.(*TaskMonitor); ok {
+			task_monitor.SetErrorClassifier(classifier)
+		}
+	})
+}
+
+// SetErrorClassifier calls SetErrorClassifier on every MonitorGroup in the
+// store, and on every MonitorGroup the store creates afterwards.
+func (s *MonitorStore) SetErrorClassifier(classifier ErrorClassifier) {
+	s.mtx.Lock()
+	s.classifier = classifier
+	s.mtx.Unlock()
+	s.eachGroup(func(group *MonitorGroup) {
+		group.SetErrorClassifier(classifier)
+	})
+}
+
+func (self *MonitorGroup) getErrorClassifier() ErrorClassifier {
+	self.mtx.Lock()
+	classifier := self.classifier
+	self.mtx.Unlock()
+	return classifier
+}
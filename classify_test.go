@@ -0,0 +1,81 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/spacemonkeygo/errors"
+	"golang.org/x/net/context"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timed out" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	custom := errors.NewClass("Custom Error")
+	for _, test := range []struct {
+		err   error
+		class string
+	}{
+		{io.EOF, "System Error"},
+		{fmt.Errorf("reading: %w", io.EOF), "System Error"},
+		{custom.New("oops"), "Custom Error"},
+		{fmt.Errorf("calling: %w", custom.New("oops")), "Custom Error"},
+		{fmt.Errorf("calling: %w", context.DeadlineExceeded),
+			"DeadlineExceeded"},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect",
+			syscall.ECONNREFUSED)}, syscall.ECONNREFUSED.Error()},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, "timeout"},
+		{fmt.Errorf("lookup: %w", &net.DNSError{Err: "no such host"}),
+			"net.DNSError"},
+	} {
+		if class := DefaultErrorClassifier(test.err); class != test.class {
+			t.Errorf("%v: %q != %q", test.err, class, test.class)
+		}
+	}
+}
+
+func TestSetErrorClassifier(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	task := func(name string, err error) {
+		defer mon.TaskNamed(name)(&err)
+	}
+	task("before", io.EOF)
+	store.SetErrorClassifier(func(err error) string { return "custom" })
+	task("before", io.EOF)
+	task("after", io.EOF)
+	store.GetMonitorsNamed("bar").TaskNamed("new")(&io.EOF)
+
+	stats := Collect(store)
+	for _, name := range []string{"foo.before.error_System_Error",
+		"foo.before.error_custom", "foo.after.error_custom",
+		"bar.new.error_custom"} {
+		if stats[name] != 1 {
+			t.Errorf("%s: expected 1, got %f", name, stats[name])
+		}
+	}
+}
@@ -21,6 +21,10 @@ var Config = struct {
 	DefaultCollectionFraction float64 `default:".1" usage:"The fraction of datapoints to collect"`
 	DefaultCollectionMax      int     `default:"500" usage:"The max datapoints to collect"`
 	MaxErrorLength            int     `default:"40" usage:"the max length for an error name"`
+	TaskMeters                bool    `default:"false" usage:"Whether new tasks track start, completion and error rates"`
+	TaskStackFraction         float64 `default:"0" usage:"The fraction of tasks that capture a stack trace when they start"`
+	TaskGoroutines            bool    `default:"false" usage:"Whether all tasks record the goroutine they started on, for the stacks view"`
+	SplitTaskValues           bool    `default:"false" usage:"Whether values recorded on tasks are also aggregated by success and failure"`
 }{
 	DefaultCollectionFraction: .1,
 	DefaultCollectionMax:      500,
@@ -0,0 +1,432 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DashboardHistoryInterval is a reasonable interval to pass to
+	// SetDashboardHistory.
+	DashboardHistoryInterval = 10 * time.Second
+
+	// DashboardHistoryPoints is a reasonable number of points to pass to
+	// SetDashboardHistory.
+	DashboardHistoryPoints = 60
+
+	// DashboardRefresh is how many seconds the dashboard waits before
+	// reloading itself, unless the request has a refresh query parameter.
+	DashboardRefresh = 10
+)
+
+// historyStats are the statistics recorded for sparklines: task throughput
+// and latency, and each other monitor's most recent value or count.
+var historyStats = map[string]bool{
+	"total_completed":   true,
+	"time_total_recent": true,
+	"recent":            true,
+	"count":             true,
+}
+
+type historySample struct {
+	time time.Time
+	vals map[string]float64
+}
+
+// statHistory records a few statistics of a MonitorStore every interval,
+// keeping the most recent points samples.
+type statHistory struct {
+	interval time.Duration
+	points   int
+	stop     chan struct{}
+
+	mtx     sync.Mutex
+	samples []historySample
+}
+
+// SetDashboardHistory makes the MonitorStore record the statistics the HTML
+// dashboard draws sparklines from every interval, keeping the last points
+// recordings. The history is off, and the dashboard has no sparklines, until
+// this is called. A points value of zero turns the history back off.
+func (s *MonitorStore) SetDashboardHistory(interval time.Duration,
+	points int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.history != nil {
+		close(s.history.stop)
+		s.history = nil
+	}
+	if points <= 0 || interval <= 0 {
+		return
+	}
+	s.history = &statHistory{
+		interval: interval,
+		points:   points,
+		stop:     make(chan struct{})}
+	go s.history.run(s)
+}
+
+// dashboardHistory returns the MonitorStore's history, or nil if it's off.
+func (s *MonitorStore) dashboardHistory() *statHistory {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.history
+}
+
+func (h *statHistory) run(s *MonitorStore) {
+	h.record(s)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.record(s)
+		}
+	}
+}
+
+func (h *statHistory) record(s *MonitorStore) {
+	sample := historySample{time: time.Now(), vals: make(map[string]float64)}
+	for _, stat := range s.collectStats() {
+		if historyStats[stat.Stat] {
+			sample.vals[stat.flatName()] = stat.Val
+		}
+	}
+	h.mtx.Lock()
+	h.samples = append(h.samples, sample)
+	if len(h.samples) > h.points {
+		h.samples = append([]historySample(nil),
+			h.samples[len(h.samples)-h.points:]...)
+	}
+	h.mtx.Unlock()
+}
+
+// series returns the recorded values of the statistic called name, oldest
+// first, with NaN where it's missing.
+func (h *statHistory) series(name string) []float64 {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	vals := make([]float64, 0, len(h.samples))
+	for _, sample := range h.samples {
+		val, ok := sample.vals[name]
+		if !ok {
+			val = math.NaN()
+		}
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+// rates returns how quickly the counter called name went up between each
+// pair of recordings, per second.
+func (h *statHistory) rates(name string) []float64 {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	var rates []float64
+	for i := 1; i < len(h.samples); i++ {
+		before, ok1 := h.samples[i-1].vals[name]
+		after, ok2 := h.samples[i].vals[name]
+		elapsed := h.samples[i].time.Sub(h.samples[i-1].time).Seconds()
+		if !ok1 || !ok2 || after < before || elapsed <= 0 {
+			rates = append(rates, math.NaN())
+			continue
+		}
+		rates = append(rates, (after-before)/elapsed)
+	}
+	return rates
+}
+
+type dashboardPage struct {
+	Refresh int
+	History bool
+	Groups  []*dashboardGroup
+	Running []dashboardRunning
+}
+
+type dashboardGroup struct {
+	Name     string
+	Tasks    []dashboardTask
+	Monitors []dashboardMonitor
+}
+
+type dashboardTask struct {
+	Name          string
+	Current       string
+	Completed     string
+	SuccessRatio  string
+	ErrorRatio    string
+	Errors        string
+	AvgLatency    string
+	RecentLatency string
+	Throughput    template.HTML
+	Latency       template.HTML
+}
+
+type dashboardMonitor struct {
+	Name  string
+	Stats string
+	Spark template.HTML
+}
+
+type dashboardRunning struct {
+	Name    string
+	Elapsed string
+}
+
+// writeDashboard writes the HTML dashboard for the groups in view to w.
+// Monitors are shown if any of their statistics match the view's filter.
+func (s *MonitorStore) writeDashboard(w io.Writer, view *storeView,
+	refresh int) (found bool, err error) {
+	history := s.dashboardHistory()
+	page := dashboardPage{Refresh: refresh, History: history != nil}
+	found = view.eachGroup(s, func(group *MonitorGroup) {
+		dash_group := &dashboardGroup{Name: group.group_name}
+		group.eachMonitor(func(cache_key string, mon Monitor) {
+			prefix := fmt.Sprintf("%s.%s.", group.group_name, cache_key)
+			var names []string
+			stats := make(map[string]float64)
+			matched := false
+			mon.Stats(func(subname string, val float64) {
+				names = append(names, subname)
+				stats[subname] = val
+				matched = matched || view.match(prefix+subname)
+			})
+			if !matched {
+				return
+			}
+			if _, ok := mon.(*TaskMonitor); ok {
+				dash_group.Tasks = append(dash_group.Tasks,
+					dashboardTaskFor(cache_key, prefix, stats, history))
+				return
+			}
+			dash_group.Monitors = append(dash_group.Monitors,
+				dashboardMonitorFor(cache_key, prefix, names, stats, history))
+		})
+		if len(dash_group.Tasks) > 0 || len(dash_group.Monitors) > 0 {
+			page.Groups = append(page.Groups, dash_group)
+		}
+	})
+	if !found {
+		return false, nil
+	}
+	running, _ := view.running(s)
+	for _, task := range running {
+		page.Running = append(page.Running, dashboardRunning{
+			Name:    task.flatName(),
+			Elapsed: formatSeconds(task.Elapsed.Seconds())})
+	}
+
+	var buf bytes.Buffer
+	err = dashboardTemplate.Execute(&buf, page)
+	if err != nil {
+		return true, Error.Wrap(err)
+	}
+	_, err = w.Write(buf.Bytes())
+	return true, err
+}
+
+func dashboardTaskFor(name, prefix string, stats map[string]float64,
+	history *statHistory) dashboardTask {
+	var errors float64
+	for subname, val := range stats {
+		if strings.HasPrefix(subname, "error_") {
+			errors += val
+		}
+	}
+	completed := stats["total_completed"]
+	task := dashboardTask{
+		Name:      name,
+		Current:   formatValue(stats["current"]),
+		Completed: formatValue(completed),
+		Errors:    formatValue(errors)}
+	if completed > 0 {
+		task.SuccessRatio = formatPercent(stats["success"] / completed)
+		task.ErrorRatio = formatPercent(errors / completed)
+	}
+	if avg, ok := stats["time_total_avg"]; ok {
+		task.AvgLatency = formatSeconds(avg)
+	}
+	if recent, ok := stats["time_total_recent"]; ok {
+		task.RecentLatency = formatSeconds(recent)
+	}
+	if history != nil {
+		task.Throughput = sparkline(history.rates(prefix + "total_completed"))
+		task.Latency = sparkline(history.series(prefix + "time_total_recent"))
+	}
+	return task
+}
+
+func dashboardMonitorFor(name, prefix string, names []string,
+	stats map[string]float64, history *statHistory) dashboardMonitor {
+	parts := make([]string, 0, len(names))
+	for _, subname := range names {
+		parts = append(parts, subname+"="+formatValue(stats[subname]))
+	}
+	mon := dashboardMonitor{Name: name, Stats: strings.Join(parts, "  ")}
+	if history != nil {
+		if _, ok := stats["recent"]; ok {
+			mon.Spark = sparkline(history.series(prefix + "recent"))
+		} else if _, ok := stats["count"]; ok {
+			mon.Spark = sparkline(history.rates(prefix + "count"))
+		}
+	}
+	return mon
+}
+
+func formatValue(val float64) string {
+	return strconv.FormatFloat(val, 'g', 6, 64)
+}
+
+func formatPercent(ratio float64) string {
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
+// formatSeconds formats a duration in seconds to three significant figures.
+func formatSeconds(seconds float64) string {
+	switch {
+	case seconds < 1e-3:
+		return fmt.Sprintf("%.3gµs", seconds*1e6)
+	case seconds < 1:
+		return fmt.Sprintf("%.3gms", seconds*1e3)
+	}
+	return fmt.Sprintf("%.3gs", seconds)
+}
+
+const (
+	sparkWidth  = 120
+	sparkHeight = 20
+)
+
+// sparkline draws vals as an inline SVG line, scaled to fit. Missing values
+// are skipped, and fewer than two values draw nothing.
+func sparkline(vals []float64) template.HTML {
+	min, max := math.Inf(1), math.Inf(-1)
+	var points int
+	for _, val := range vals {
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			continue
+		}
+		min, max = math.Min(min, val), math.Max(max, val)
+		points++
+	}
+	if points < 2 {
+		return ""
+	}
+	var coords []string
+	for i, val := range vals {
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			continue
+		}
+		x := float64(i) * (sparkWidth - 1) / float64(len(vals)-1)
+		y := float64(sparkHeight) / 2
+		if max > min {
+			y = sparkHeight - 1 - (val-min)/(max-min)*(sparkHeight-2)
+		}
+		coords = append(coords, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg class="spark" width="%d" height="%d">`+
+			`<title>min %s, max %s</title>`+
+			`<polyline points="%s"/></svg>`,
+		sparkWidth, sparkHeight, formatValue(min), formatValue(max),
+		strings.Join(coords, " ")))
+}
+
+// dashboardFormat returns whether req asks for the HTML dashboard.
+func dashboardFormat(req *http.Request) bool {
+	return req.URL.Query().Get("format") == "html"
+}
+
+// dashboardRefresh returns how many seconds the dashboard should wait before
+// reloading, from the refresh query parameter.
+func dashboardRefresh(req *http.Request) int {
+	refresh, err := strconv.Atoi(req.URL.Query().Get("refresh"))
+	if err != nil || refresh < 0 {
+		return DashboardRefresh
+	}
+	return refresh
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{if .Refresh}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+<title>monitor</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 1em 2em; color: #222; }
+h1 { font-size: 18px; }
+h2 { font-size: 15px; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { padding: 2px 8px; text-align: right; vertical-align: middle; }
+th { background: #eee; }
+td.name, th.name { text-align: left; font-family: monospace; }
+td.stats { text-align: left; font-family: monospace; color: #555; }
+tr:nth-child(even) td { background: #f8f8f8; }
+.bad { color: #b00; }
+.spark polyline { fill: none; stroke: #36c; stroke-width: 1.5; }
+.note { color: #777; }
+</style>
+</head>
+<body>
+<h1>monitor</h1>
+{{if not .History}}<p class="note">History is off, so there are no sparklines.</p>{{end}}
+
+<h2>running tasks</h2>
+{{if .Running}}
+<table>
+<tr><th>elapsed</th><th class="name">task</th></tr>
+{{range .Running}}<tr><td>{{.Elapsed}}</td><td class="name">{{.Name}}</td></tr>
+{{end}}</table>
+{{else}}<p class="note">None.</p>{{end}}
+
+{{range .Groups}}
+<h2>{{.Name}}</h2>
+{{if .Tasks}}
+<table>
+<tr><th class="name">task</th><th>running</th><th>completed</th>
+<th>success</th><th>errors</th><th>error rate</th>
+<th>avg latency</th><th>recent latency</th>
+<th>throughput</th><th>latency</th></tr>
+{{range .Tasks}}<tr><td class="name">{{.Name}}</td><td>{{.Current}}</td>
+<td>{{.Completed}}</td><td>{{.SuccessRatio}}</td><td>{{.Errors}}</td>
+<td{{if ne .Errors "0"}} class="bad"{{end}}>{{.ErrorRatio}}</td>
+<td>{{.AvgLatency}}</td><td>{{.RecentLatency}}</td>
+<td>{{.Throughput}}</td><td>{{.Latency}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Monitors}}
+<table>
+<tr><th class="name">monitor</th><th></th><th class="name">stats</th></tr>
+{{range .Monitors}}<tr><td class="name">{{.Name}}</td><td>{{.Spark}}</td>
+<td class="stats">{{.Stats}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
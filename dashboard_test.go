@@ -0,0 +1,100 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	task := func(err error) {
+		defer mon.TaskNamed("work")(&err)
+	}
+	task(nil)
+	task(io.EOF)
+	finish := mon.TaskNamed("slow")
+	defer finish(nil)
+	mon.Val("size", 3)
+	store.GetMonitorsNamed("bar").EventNamed("hits")
+
+	req, err := http.NewRequest("GET", "/dashboard/foo?refresh=5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct,
+		"text/html") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, expected := range []string{
+		`content="5"`, "<h2>foo</h2>", ">work<", "50.0%", ">size<",
+		"foo.slow", "History is off"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("dashboard is missing %q:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "bar") {
+		t.Errorf("dashboard should be scoped to foo:\n%s", body)
+	}
+	if store.dashboardHistory() != nil {
+		t.Errorf("serving the dashboard shouldn't start its history")
+	}
+
+	req, err = http.NewRequest("GET", "/?format=html&filter=bar.", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	store.ServeHTTP(rec, req)
+	body = rec.Body.String()
+	if !strings.Contains(body, ">hits<") || strings.Contains(body,
+		"<h2>foo</h2>") {
+		t.Errorf("unexpected filtered dashboard:\n%s", body)
+	}
+}
+
+func TestDashboardHistory(t *testing.T) {
+	now := time.Now()
+	h := &statHistory{points: 2}
+	h.samples = []historySample{
+		{time: now, vals: map[string]float64{"a.b.count": 1}},
+		{time: now.Add(time.Second), vals: map[string]float64{"a.b.count": 3}},
+		{time: now.Add(2 * time.Second), vals: map[string]float64{}},
+	}
+	rates := h.rates("a.b.count")
+	if len(rates) != 2 || rates[0] != 2 || !math.IsNaN(rates[1]) {
+		t.Errorf("unexpected rates: %v", rates)
+	}
+
+	if sparkline([]float64{1, math.NaN()}) != "" {
+		t.Errorf("a single point shouldn't draw a sparkline")
+	}
+	spark := string(sparkline([]float64{1, math.NaN(), 3}))
+	if !strings.Contains(spark, `points="0.0,19.0 119.0,1.0"`) {
+		t.Errorf("unexpected sparkline: %s", spark)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/errors"
+)
+
+// DataTaskCtx keeps track of a task started with MonitorGroup.DataTask,
+// DataTaskNamed or NewDataTask. When the task finishes, it's recorded by a
+// TaskMonitor as usual, and a datapoint about it is added to the
+// DatapointCollector by the same name. The datapoint's dimensions are
+//
+//	(duration, success, error class, start time, added...)
+//
+// where duration is in seconds, success is 1 or 0, error class is
+// ErrorClassHash of the error's bucket (0 on success), start time is in
+// seconds since the Unix epoch, and added are any values passed to Add.
+type DataTaskCtx struct {
+	start     time.Time
+	monitor   *TaskMonitor
+	collector *DatapointCollector
+	end       func(e *error, rec interface{})
+
+	mtx   sync.Mutex
+	added []float64
+}
+
+// Add appends vals to the dimensions of the task's datapoint. Tasks by the
+// same name should add the same number of values so their datapoints line
+// up.
+func (d *DataTaskCtx) Add(vals ...float64) {
+	d.mtx.Lock()
+	d.added = append(d.added, vals...)
+	d.mtx.Unlock()
+}
+
+// Finish records the task. You must pass a pointer to the named error return
+// value (or nil if there isn't one), and defer Finish directly, so that it
+// can see and re-panic any panics.
+func (d *DataTaskCtx) Finish(err_ref *error) {
+	if d.end == nil {
+		return
+	}
+	d.finish(err_ref, recover())
+}
+
+func (d *DataTaskCtx) finish(err_ref *error, rec interface{}) {
+	if d.end == nil {
+		if rec != nil {
+			panic(rec)
+		}
+		return
+	}
+	duration := time.Since(d.start).Seconds()
+	var err error
+	if err_ref != nil {
+		err = *err_ref
+	}
+	if rec != nil {
+		var ok bool
+		err, ok = rec.(error)
+		if !ok || err == nil {
+			err = errors.PanicError.New("%v", rec)
+		}
+	}
+
+	success, class := 1.0, 0.0
+	if err != nil {
+		success = 0
+		error_name := contextErrorName(nil, err)
+		if error_name == "" || rec != nil {
+			error_name = d.monitor.classify(err)
+		}
+		class = ErrorClassHash(error_name)
+	}
+
+	d.mtx.Lock()
+	point := make([]float64, 0, 4+len(d.added))
+	point = append(point, duration, success, class,
+		float64(d.start.UnixNano())/float64(time.Second))
+	point = append(point, d.added...)
+	d.mtx.Unlock()
+	if d.collector != nil {
+		d.collector.Add(point...)
+	}
+	d.end(err_ref, rec)
+}
+
+// ErrorClassHash returns the number a DataTaskCtx records for errors in the
+// bucket called name, as in the error_<name> stat. It's a 32 bit FNV-1a hash,
+// so it's the same across runs and processes.
+func ErrorClassHash(name string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return float64(h.Sum32())
+}
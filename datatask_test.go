@@ -0,0 +1,82 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDataTask(t *testing.T) {
+	defer func(fraction float64) {
+		Config.DefaultCollectionFraction = fraction
+	}(Config.DefaultCollectionFraction)
+	Config.DefaultCollectionFraction = 1
+
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	plain := func(err error) {
+		defer mon.DataTaskNamed("plain")(&err)
+	}
+	sized := func(size int, err error) {
+		task := mon.NewDataTask("sized")
+		defer task.Finish(&err)
+		task.Add(float64(size))
+	}
+	panicky := func() {
+		defer func() { recover() }()
+		task := mon.NewDataTask("sized")
+		defer task.Finish(nil)
+		panic("oh no")
+	}
+
+	before := float64(time.Now().Unix())
+	plain(nil)
+	plain(io.EOF)
+	sized(10, nil)
+	panicky()
+
+	datasets := store.Snapshot().Datasets
+	if len(datasets) != 2 {
+		t.Fatalf("unexpected datasets: %v", datasets)
+	}
+	plain_data, sized_data := datasets[0].Data, datasets[1].Data
+	if len(plain_data) != 2 || len(sized_data) != 2 {
+		t.Fatalf("unexpected datasets: %v", datasets)
+	}
+	for _, point := range append(plain_data, sized_data...) {
+		if point[0] < 0 || point[0] > 1 || point[3] < before {
+			t.Errorf("unexpected duration or start: %v", point)
+		}
+	}
+	if plain_data[0][1] != 1 || plain_data[0][2] != 0 ||
+		plain_data[1][1] != 0 ||
+		plain_data[1][2] != ErrorClassHash("System_Error") {
+		t.Errorf("unexpected plain datapoints: %v", plain_data)
+	}
+	if len(sized_data[0]) != 5 || sized_data[0][4] != 10 ||
+		len(sized_data[1]) != 4 || sized_data[1][1] != 0 {
+		t.Errorf("unexpected sized datapoints: %v", sized_data)
+	}
+
+	stats := Collect(store)
+	if stats["foo.plain.total_completed"] != 2 ||
+		stats["foo.sized.panics"] != 1 {
+		t.Errorf("tasks weren't recorded: %v", stats)
+	}
+}
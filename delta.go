@@ -0,0 +1,116 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"strings"
+	"sync"
+)
+
+// DeltaMonitor wraps a Monitor and reports how much its counters have grown
+// since the DeltaMonitor's last Stats call, instead of their running totals.
+// Counters are the stats the Monitor describes as Counter, through
+// DescribedStats. Gauges, like current or avg, are passed through unchanged.
+// The first call reports the whole total, and a counter that went down, such
+// as after its monitor was removed and recreated, reports its new total.
+//
+// A DeltaMonitor only remembers what it reported itself, so several
+// consumers can each wrap the same Monitor in their own DeltaMonitor without
+// affecting each other. Stats from monitors that don't describe themselves
+// are treated as counters when their names are ones the built-in monitors
+// use for counters, like count, success or error_*.
+type DeltaMonitor struct {
+	mon Monitor
+
+	mtx  sync.Mutex
+	last map[string]float64
+}
+
+// NewDeltaMonitor makes a DeltaMonitor for mon.
+func NewDeltaMonitor(mon Monitor) *DeltaMonitor {
+	return &DeltaMonitor{mon: mon, last: make(map[string]float64)}
+}
+
+// Stats conforms to the Monitor interface
+func (d *DeltaMonitor) Stats(cb func(name string, val float64)) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	seen := make(map[string]float64, len(d.last))
+	DescribedStats(d.mon, func(name string, info StatInfo, val float64) {
+		cb(name, d.delta(seen, name, name, info, val))
+	})
+	d.last = seen
+}
+
+// TaggedStats conforms to the TaggedMonitor interface. If the wrapped Monitor
+// isn't a TaggedMonitor, all stats are reported without tags.
+func (d *DeltaMonitor) TaggedStats(
+	cb func(name string, tags map[string]string, val float64)) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	seen := make(map[string]float64, len(d.last))
+	report := func(name string, tags map[string]string, info StatInfo,
+		val float64) {
+		cb(name, tags, d.delta(seen, taggedName(name, tags), name, info, val))
+	}
+	switch mon := d.mon.(type) {
+	case taggedDescribedMonitor:
+		mon.taggedDescribedStats(report)
+	case TaggedMonitor:
+		mon.TaggedStats(func(name string, tags map[string]string,
+			val float64) {
+			report(name, tags, StatInfo{Kind: Untyped}, val)
+		})
+	default:
+		DescribedStats(d.mon, func(name string, info StatInfo, val float64) {
+			report(name, nil, info, val)
+		})
+	}
+	d.last = seen
+}
+
+// delta records val under key in seen and returns what to report for the
+// stat called name. d.mtx must be held.
+func (d *DeltaMonitor) delta(seen map[string]float64, key, name string,
+	info StatInfo, val float64) float64 {
+	seen[key] = val
+	counter := info.Kind == Counter
+	if info.Kind == Untyped {
+		counter = isCounter(name)
+	}
+	if !counter {
+		return val
+	}
+	last, ok := d.last[key]
+	if !ok || val < last {
+		return val
+	}
+	return val - last
+}
+
+// isCounter returns whether the stat called name only ever goes up, going by
+// the names the built-in monitors use. It's only used for stats that weren't
+// described.
+func isCounter(name string) bool {
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	switch name {
+	case "count", "panics", "success", "total_completed", "total_started":
+		return true
+	}
+	return strings.HasPrefix(name, "error_") ||
+		strings.Contains(name, "bucket_le_")
+}
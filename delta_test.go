@@ -0,0 +1,78 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDeltaMonitor(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	task := func(err error) {
+		defer mon.TaskNamed("bar")(&err)
+	}
+	task(nil)
+	task(io.EOF)
+	mon.Val("size", 5)
+
+	first := NewDeltaMonitor(mon)
+	second := NewDeltaMonitor(mon)
+	stats := Collect(first)
+	if stats["foo.bar.total_started"] != 2 || stats["foo.size.count"] != 1 {
+		t.Errorf("first report should have totals: %v", stats)
+	}
+
+	task(io.EOF)
+	mon.Val("size", 7)
+	stats = Collect(first)
+	if stats["foo.bar.total_started"] != 1 || stats["foo.bar.success"] != 0 ||
+		stats["foo.bar.error_System_Error"] != 1 || stats["foo.size.count"] != 1 {
+		t.Errorf("unexpected deltas: %v", stats)
+	}
+	if stats["foo.size.max"] != 7 || stats["foo.bar.highwater"] != 1 {
+		t.Errorf("gauges should pass through: %v", stats)
+	}
+
+	// the second consumer wasn't reset by the first
+	stats = Collect(second)
+	if stats["foo.bar.total_started"] != 3 {
+		t.Errorf("consumers not independent: %v", stats)
+	}
+
+	// a recreated monitor starts over
+	mon.Remove("size")
+	mon.Val("size", 1)
+	stats = Collect(first)
+	if stats["foo.size.count"] != 1 {
+		t.Errorf("reset counter not reported: %v", stats)
+	}
+}
+
+func TestDeltaMonitorKinds(t *testing.T) {
+	windowed := NewWindowedValueMonitor(time.Hour)
+	windowed.Add(1)
+	windowed.Add(2)
+	delta := NewDeltaMonitor(windowed)
+	Collect(delta)
+	windowed.Add(3)
+	stats := Collect(delta)
+	if stats["count"] != 3 {
+		t.Errorf("windowed count should pass through: %v", stats)
+	}
+}
@@ -74,6 +74,33 @@ monitor.Stats like so
 		// do something with name, val
 	})
 
+Requests for a path ending in "metrics" (or that send a Prometheus or
+OpenMetrics Accept header) are answered in the Prometheus text exposition
+format, so the same handler can be scraped directly. Adding ?format=json to
+any of the handler's paths (or sending a JSON Accept header) returns the
+stats, running tasks or datapoints as JSON instead of text.
+
+Large processes have a lot of stats, so the handler can be narrowed down.
+Paths like /stats/<group>, /running/<group> and /datapoints/<group> only walk
+that one group, and a filter query parameter keeps only the names that start
+with it, that match it as a glob if it contains *, ? or [, or that match it as
+a regular expression if it's wrapped in slashes:
+
+	curl 'localhost:8080/stats/main?filter=*.error_*'
+	curl 'localhost:8080/running?filter=/^main\.(load|save)/'
+
+For a browser, a path ending in "dashboard" (or ?format=html) shows an HTML
+dashboard that needs nothing but the process itself. It lists the running
+tasks, and each group's task success and error ratios and latencies, with
+sparklines of recent history, and reloads itself every few seconds.
+
+When a task hangs, a path ending in "stacks" shows where: every task running
+for longer than ?threshold= (10s by default) is listed with the current stack
+of the goroutine that started it, and, for the Config.TaskStackFraction of
+tasks that record one, the stack from when it started.
+
+	curl 'localhost:8080/stacks/main?threshold=1m'
+
 This package lets you easily instrument your code with all of these goodies and
 more!
 
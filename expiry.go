@@ -0,0 +1,126 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/spacemonkeygo/monotime"
+)
+
+// Remove drops the monitor or datapoint collector by the given name from the
+// MonitorGroup, so that it no longer shows up in Stats, Running or
+// Datapoints. Using the name again creates a fresh monitor.
+func (self *MonitorGroup) Remove(name string) {
+	self.remove(SanitizeName(name))
+}
+
+func (self *MonitorGroup) remove(name string) {
+	self.names_mtx.Lock()
+	defer self.names_mtx.Unlock()
+	dropped := self.monitors.Drop(name)
+	if self.collectors.Drop(name) {
+		dropped = true
+	}
+	if dropped {
+		self.grew(-1)
+	}
+}
+
+// Remove works like MonitorGroup.Remove, but for the monitor with this view's
+// tags.
+func (self *TaggedMonitorGroup) Remove(name string) {
+	self.group.remove(self.name(name))
+}
+
+// SetIdleExpiry makes the MonitorGroup evict monitors and datapoint
+// collectors that haven't been used in expiry. Evictions happen as new
+// monitors are created and as the group's stats are collected, at most once
+// every half expiry. Chained monitors and task monitors with running tasks
+// are never evicted. An expiry of zero, the default, turns eviction off.
+func (self *MonitorGroup) SetIdleExpiry(expiry time.Duration) {
+	self.mtx.Lock()
+	self.idle_expiry = expiry
+	self.mtx.Unlock()
+}
+
+// expireIdle evicts idle monitors, if idle expiry is on and it's been long
+// enough since the last time.
+func (self *MonitorGroup) expireIdle() {
+	now := monotime.Monotonic()
+	self.mtx.Lock()
+	expiry := self.idle_expiry
+	if expiry <= 0 || now-self.last_expiry < expiry/2 {
+		self.mtx.Unlock()
+		return
+	}
+	self.last_expiry = now
+	self.mtx.Unlock()
+
+	self.names_mtx.Lock()
+	defer self.names_mtx.Unlock()
+	dropped := append(self.monitors.DropIdle(expiry, keepIdle),
+		self.collectors.DropIdle(expiry, keepIdle)...)
+	gone := make(map[interface{}]bool, len(dropped))
+	for _, name := range dropped {
+		if !self.monitors.Contains(name) && !self.collectors.Contains(name) {
+			gone[name] = true
+		}
+	}
+	self.grew(-len(gone))
+}
+
+// keepIdle protects monitors that are idle by nature from expiry.
+func keepIdle(_, val interface{}) bool {
+	switch mon := val.(type) {
+	case *ChainedMonitor:
+		// chained monitors are set once and then only ever read
+		return true
+	case *TaskMonitor:
+		return mon.isRunning()
+	}
+	return false
+}
+
+// RemoveGroup drops the MonitorGroup by the given name from the MonitorStore.
+// Anything still holding the MonitorGroup can keep using it, but its
+// statistics are no longer reported by the store.
+func (s *MonitorStore) RemoveGroup(group_name string) {
+	group_name = SanitizeName(group_name)
+	cached, ok := s.groups.Lookup(group_name)
+	if ok && s.groups.Drop(group_name) {
+		if group, ok := cached.(*MonitorGroup); ok {
+			// the group no longer counts against the store's limit
+			group.mtx.Lock()
+			group.store = nil
+			group.mtx.Unlock()
+			atomic.AddInt64(&s.size, -int64(group.size()))
+		}
+	}
+}
+
+// SetIdleExpiry calls SetIdleExpiry on every MonitorGroup in the store, and
+// on every MonitorGroup the store creates afterwards.
+func (s *MonitorStore) SetIdleExpiry(expiry time.Duration) {
+	s.mtx.Lock()
+	s.idle_expiry = expiry
+	s.mtx.Unlock()
+	for _, cache_val := range s.groups.Snapshot() {
+		if group, ok := cache_val.(*MonitorGroup); ok {
+			group.SetIdleExpiry(expiry)
+		}
+	}
+}
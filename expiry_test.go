@@ -0,0 +1,79 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleExpiry(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	mon.Chain("chained", MonitorFunc(func(cb func(string, float64)) {
+		cb("val", 1)
+	}))
+	mon.EventNamed("old")
+	finish := mon.TaskNamed("running")
+	mon.SetIdleExpiry(time.Millisecond)
+
+	time.Sleep(5 * time.Millisecond)
+	// creating a new monitor sweeps out the idle ones
+	mon.EventNamed("new")
+
+	stats := Collect(mon)
+	if _, ok := stats["foo.old.count"]; ok {
+		t.Errorf("idle event not expired: %v", stats)
+	}
+	if stats["foo.chained.val"] != 1 {
+		t.Errorf("chained monitor expired: %v", stats)
+	}
+	if stats["foo.running.current"] != 1 {
+		t.Errorf("running task expired: %v", stats)
+	}
+	finish(nil)
+
+	// collecting stats sweeps them out too, with no new monitors
+	time.Sleep(5 * time.Millisecond)
+	stats = Collect(mon)
+	if _, ok := stats["foo.new.count"]; ok {
+		t.Errorf("idle event not expired by Stats: %v", stats)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("bar")
+	mon.WithTags(map[string]string{"a": "b"}).EventNamed("bar")
+	mon.Data("baz", 1)
+
+	mon.Remove("bar")
+	mon.Remove("baz")
+	stats := Collect(store)
+	if len(stats) != 1 || stats["foo.bar,a=b.count"] != 1 {
+		t.Errorf("unexpected stats after Remove: %v", stats)
+	}
+	store.Datapoints(false, func(name string, data [][]float64,
+		total uint64, clipped bool, fraction float64) {
+		t.Errorf("unexpected dataset %s after Remove", name)
+	})
+
+	store.RemoveGroup("foo")
+	if stats := Collect(store); len(stats) != 0 {
+		t.Errorf("unexpected stats after RemoveGroup: %v", stats)
+	}
+}
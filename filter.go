@@ -0,0 +1,164 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	viewStats      = "stats"
+	viewRunning    = "running"
+	viewDatapoints = "datapoints"
+	viewDashboard  = "dashboard"
+	viewStacks     = "stacks"
+)
+
+// storeView is the part of a MonitorStore an HTTP request asks for: one kind
+// of data, optionally limited to a single group and to names that match a
+// filter.
+type storeView struct {
+	kind  string
+	group string
+	match func(name string) bool
+}
+
+// parseStoreView works out the storeView for req. The kind comes from the
+// end of the path, as it always has, unless the path ends in
+// "/<kind>/<group>", which also limits the view to that group. A path that
+// ends in a kind is never read as a group, so the existing views still work
+// with the handler mounted under a path like "/stats/". The filter query
+// parameter limits it to matching names; see compileFilter.
+func parseStoreView(req *http.Request) (*storeView, error) {
+	view := &storeView{kind: viewStats}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch n := len(parts); {
+	case isViewKind(parts[n-1]):
+		view.kind = parts[n-1]
+	case n >= 2 && isViewKind(parts[n-2]):
+		view.kind, view.group = parts[n-2], SanitizeName(parts[n-1])
+	case strings.HasSuffix(req.URL.Path, viewRunning):
+		view.kind = viewRunning
+	case strings.HasSuffix(req.URL.Path, viewDatapoints):
+		view.kind = viewDatapoints
+	case strings.HasSuffix(req.URL.Path, viewDashboard):
+		view.kind = viewDashboard
+	case strings.HasSuffix(req.URL.Path, viewStacks):
+		view.kind = viewStacks
+	}
+	match, err := compileFilter(req.URL.Query().Get("filter"))
+	if err != nil {
+		return nil, err
+	}
+	view.match = match
+	return view, nil
+}
+
+func isViewKind(kind string) bool {
+	switch kind {
+	case viewStats, viewRunning, viewDatapoints, viewDashboard, viewStacks:
+		return true
+	}
+	return false
+}
+
+// compileFilter returns a function that reports whether a name matches
+// filter. A filter wrapped in slashes, like "/err(or)?_/", is a regular
+// expression that matches anywhere in the name. A filter with any of the
+// glob characters "*?[" is a glob that must match the whole name, where "*"
+// matches any run of characters, dots included. Any other filter matches
+// names that start with it. An empty filter matches everything.
+func compileFilter(filter string) (func(name string) bool, error) {
+	switch {
+	case filter == "":
+		return func(string) bool { return true }, nil
+	case len(filter) >= 2 && strings.HasPrefix(filter, "/") &&
+		strings.HasSuffix(filter, "/"):
+		re, err := regexp.Compile(filter[1 : len(filter)-1])
+		if err != nil {
+			return nil, Error.New("invalid filter %q: %s", filter, err)
+		}
+		return re.MatchString, nil
+	case strings.ContainsAny(filter, "*?["):
+		// names never contain slashes, so path.Match's "*" matches dots too
+		_, err := path.Match(filter, "")
+		if err != nil {
+			return nil, Error.New("invalid filter %q: %s", filter, err)
+		}
+		return func(name string) bool {
+			matched, _ := path.Match(filter, name)
+			return matched
+		}, nil
+	}
+	return func(name string) bool {
+		return strings.HasPrefix(name, filter)
+	}, nil
+}
+
+// eachGroup calls cb with each of s's groups in the view, and returns false
+// if the view is limited to a group s doesn't have.
+func (v *storeView) eachGroup(s *MonitorStore,
+	cb func(group *MonitorGroup)) (found bool) {
+	s.eachGroup(func(group *MonitorGroup) {
+		if v.group == "" || group.group_name == v.group {
+			found = true
+			cb(group)
+		}
+	})
+	return found || v.group == ""
+}
+
+// stats returns the statistics in the view.
+func (v *storeView) stats(s *MonitorStore) (stats []Stat, found bool) {
+	found = v.eachGroup(s, func(group *MonitorGroup) {
+		for _, stat := range group.collectStats() {
+			if v.match(stat.flatName()) {
+				stats = append(stats, stat)
+			}
+		}
+	})
+	return stats, found
+}
+
+// running returns the running tasks in the view, longest running first.
+func (v *storeView) running(s *MonitorStore) (
+	running []RunningTask, found bool) {
+	found = v.eachGroup(s, func(group *MonitorGroup) {
+		for _, task := range group.collectRunning() {
+			if v.match(task.flatName()) {
+				running = append(running, task)
+			}
+		}
+	})
+	sort.Sort(runningTasks(running))
+	return running, found
+}
+
+// datasets returns the datasets in the view, without resetting them.
+func (v *storeView) datasets(s *MonitorStore) (
+	datasets []Dataset, found bool) {
+	found = v.eachGroup(s, func(group *MonitorGroup) {
+		for _, dataset := range group.collectDatasets(false) {
+			if v.match(dataset.flatName()) {
+				datasets = append(datasets, dataset)
+			}
+		}
+	})
+	return datasets, found
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveText(t *testing.T, store *MonitorStore, url string) (
+	code int, lines []string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, req)
+	body := strings.TrimSpace(rec.Body.String())
+	if body == "" {
+		return rec.Code, nil
+	}
+	return rec.Code, strings.Split(body, "\n")
+}
+
+func TestCompileFilter(t *testing.T) {
+	for _, test := range []struct {
+		filter  string
+		name    string
+		matched bool
+	}{
+		{"", "foo.bar.count", true},
+		{"foo.b", "foo.bar.count", true},
+		{"foo.b", "xfoo.bar.count", false},
+		{"*.count", "foo.bar.count", true},
+		{"*.count", "foo.bar.count_x", false},
+		{"foo.?ar.*", "foo.bar.max", true},
+		{"/^foo\\..*(max|min)$/", "foo.bar.max", true},
+		{"/error_/", "foo.bar.error_EOF", true},
+		{"/error_/", "foo.bar.success", false},
+	} {
+		match, err := compileFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match(test.name) != test.matched {
+			t.Errorf("filter %q on %q: expected %v", test.filter, test.name,
+				test.matched)
+		}
+	}
+	for _, filter := range []string{"/(/", "foo[", "[]"} {
+		if _, err := compileFilter(filter); err == nil {
+			t.Errorf("filter %q should be invalid", filter)
+		}
+	}
+}
+
+func TestServeHTTPFilter(t *testing.T) {
+	store := NewMonitorStore()
+	foo := store.GetMonitorsNamed("foo")
+	foo.EventNamed("hits")
+	foo.Val("size", 3)
+	finish := foo.TaskNamed("slow")
+	defer finish(nil)
+	store.GetMonitorsNamed("bar").EventNamed("hits")
+
+	_, lines := serveText(t, store, "/?filter=*.hits.count")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "bar.hits.count\t") ||
+		!strings.HasPrefix(lines[1], "foo.hits.count\t") {
+		t.Errorf("unexpected filtered stats: %q", lines)
+	}
+
+	_, lines = serveText(t, store, "/stats/foo?filter=foo.size.")
+	if len(lines) != 7 {
+		t.Errorf("unexpected scoped stats: %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "foo.size.") {
+			t.Errorf("unexpected scoped stat: %q", line)
+		}
+	}
+
+	_, lines = serveText(t, store, "/running/foo")
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], "\tfoo.slow") {
+		t.Errorf("unexpected running tasks: %q", lines)
+	}
+	_, lines = serveText(t, store, "/running/bar")
+	if len(lines) != 0 {
+		t.Errorf("unexpected running tasks: %q", lines)
+	}
+
+	// views under a /stats/ mount aren't mistaken for groups
+	code, lines := serveText(t, store, "/stats/running")
+	if code != http.StatusOK || len(lines) != 1 ||
+		!strings.HasSuffix(lines[0], "\tfoo.slow") {
+		t.Errorf("unexpected mounted running tasks %d: %q", code, lines)
+	}
+	code, _ = serveText(t, store, "/stats/datapoints")
+	if code != http.StatusOK {
+		t.Errorf("unexpected mounted datapoints status %d", code)
+	}
+
+	var stats map[string]map[string]map[string]*float64
+	serveJSON(t, store, "/stats/bar?format=json", &stats)
+	if len(stats) != 1 || stats["bar"]["hits"]["count"] == nil {
+		t.Errorf("unexpected scoped json: %v", stats)
+	}
+
+	code, _ = serveText(t, store, "/stats/baz")
+	if code != http.StatusNotFound {
+		t.Errorf("expected not found for a missing group, got %d", code)
+	}
+	code, _ = serveText(t, store, "/?filter=/(/")
+	if code != http.StatusBadRequest {
+		t.Errorf("expected bad request for a bad filter, got %d", code)
+	}
+}
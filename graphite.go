@@ -0,0 +1,299 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/spacemonkeygo/monitor.v1/trace"
+)
+
+const (
+	// graphitePickleBatch is how many metrics go in each pickle protocol
+	// message.
+	graphitePickleBatch = 500
+)
+
+// graphiteMetric is a single Graphite datapoint.
+type graphiteMetric struct {
+	path string
+	val  float64
+}
+
+// GraphiteExporter periodically sends all of a Monitor's statistics to a
+// Graphite (Carbon) server over TCP, in either the plaintext or the pickle
+// protocol. It reconnects with a backoff when the connection fails. Stats
+// from intervals spent disconnected are skipped rather than buffered, since
+// the next interval's stats supersede them. Connecting and each write must
+// finish within an interval, so a stalled server can't hold it up.
+type GraphiteExporter struct {
+	mon      Monitor
+	addr     *net.TCPAddr
+	prefix   string
+	interval time.Duration
+	pickle   bool
+	done     chan struct{}
+	stopped  chan struct{}
+	closing  sync.Once
+}
+
+// NewGraphiteExporter creates a GraphiteExporter that sends mon's stats to the
+// plaintext protocol listener at graphite_addr, typically "127.0.0.1:2003",
+// every interval. If prefix isn't empty, it's added to the front of every
+// metric path.
+func NewGraphiteExporter(mon Monitor, graphite_addr, prefix string,
+	interval time.Duration) (*GraphiteExporter, error) {
+	return newGraphiteExporter(mon, graphite_addr, prefix, interval, false)
+}
+
+// NewGraphitePickleExporter works like NewGraphiteExporter, but uses the
+// pickle protocol, whose listener is typically at "127.0.0.1:2004".
+func NewGraphitePickleExporter(mon Monitor, graphite_addr, prefix string,
+	interval time.Duration) (*GraphiteExporter, error) {
+	return newGraphiteExporter(mon, graphite_addr, prefix, interval, true)
+}
+
+func newGraphiteExporter(mon Monitor, graphite_addr, prefix string,
+	interval time.Duration, pickle bool) (*GraphiteExporter, error) {
+	addr, err := net.ResolveTCPAddr("tcp", graphite_addr)
+	if err != nil {
+		return nil, err
+	}
+	g := &GraphiteExporter{
+		mon:      mon,
+		addr:     addr,
+		prefix:   SanitizeName(prefix),
+		interval: interval,
+		pickle:   pickle,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{})}
+	go g.pumpWrites()
+	return g, nil
+}
+
+// Close stops the GraphiteExporter, and waits for it to close its
+// connection. Closing it again does nothing.
+func (g *GraphiteExporter) Close() error {
+	g.closing.Do(func() { close(g.done) })
+	<-g.stopped
+	return nil
+}
+
+// pumpWrites connects to Graphite, sends stats every interval, and
+// reconnects with a backoff on consecutive errors. The backoff is only reset
+// once a write succeeds, so a server that accepts connections and then drops
+// them is backed off from too.
+func (g *GraphiteExporter) pumpWrites() {
+	defer close(g.stopped)
+	var backoff int
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-time.After(trace.DefaultBackoff.Duration(backoff)):
+		}
+
+		conn, err := net.DialTimeout("tcp", g.addr.String(), g.interval)
+		if err != nil {
+			logger.Errorf("graphite connect error: %s", err)
+			backoff++
+			continue
+		}
+
+		wrote, err := g.writeAll(conn)
+		conn.Close()
+		if wrote {
+			backoff = 0
+		}
+		if err != nil {
+			logger.Errorf("graphite write error: %s", err)
+			backoff++
+		}
+	}
+}
+
+// writeAll sends stats to conn every interval until a write fails or g is
+// closed. It returns whether any writes succeeded.
+func (g *GraphiteExporter) writeAll(conn net.Conn) (wrote bool, err error) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return wrote, nil
+		case now := <-ticker.C:
+			err = conn.SetWriteDeadline(time.Now().Add(g.interval))
+			if err != nil {
+				return wrote, err
+			}
+			if g.pickle {
+				err = WriteGraphitePickle(conn, g.mon, g.prefix, now)
+			} else {
+				err = WriteGraphite(conn, g.mon, g.prefix, now)
+			}
+			if err != nil {
+				return wrote, err
+			}
+			wrote = true
+		}
+	}
+}
+
+// GraphiteSink is a Sink that writes each Report to a Graphite server over a
+// fresh TCP connection. Use it instead of a GraphiteExporter when reporting
+// through a Reporter.
+type GraphiteSink struct {
+	addr   string
+	prefix string
+	pickle bool
+}
+
+// NewGraphiteSink creates a GraphiteSink that writes to graphite_addr, using
+// the pickle protocol if pickle is true and the plaintext protocol
+// otherwise. If prefix isn't empty, it's added to the front of every metric
+// path.
+func NewGraphiteSink(graphite_addr, prefix string, pickle bool) *GraphiteSink {
+	return &GraphiteSink{
+		addr:   graphite_addr,
+		prefix: SanitizeName(prefix),
+		pickle: pickle}
+}
+
+// Send conforms to the Sink interface
+func (g *GraphiteSink) Send(ctx context.Context, report *Report) error {
+	deadline, _ := ctx.Deadline()
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.Dial("tcp", g.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return err
+	}
+	if g.pickle {
+		return WriteGraphitePickle(conn, report, g.prefix, report.Time)
+	}
+	return WriteGraphite(conn, report, g.prefix, report.Time)
+}
+
+// WriteGraphite writes all of mon's statistics to w as Graphite plaintext
+// protocol lines of the form "path value timestamp". Tags on monitors made
+// through a TaggedMonitorGroup are written using Graphite's path;key=value
+// tag syntax. Values that aren't finite are skipped.
+func WriteGraphite(w io.Writer, mon Monitor, prefix string,
+	now time.Time) error {
+	var buf bytes.Buffer
+	for _, metric := range graphiteMetrics(mon, prefix) {
+		fmt.Fprintf(&buf, "%s %s %d\n", metric.path,
+			strconv.FormatFloat(metric.val, 'f', -1, 64), now.Unix())
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteGraphitePickle works like WriteGraphite, but writes Graphite pickle
+// protocol messages.
+func WriteGraphitePickle(w io.Writer, mon Monitor, prefix string,
+	now time.Time) error {
+	metrics := graphiteMetrics(mon, prefix)
+	var buf bytes.Buffer
+	for len(metrics) > 0 {
+		batch := metrics
+		if len(batch) > graphitePickleBatch {
+			batch = batch[:graphitePickleBatch]
+		}
+		metrics = metrics[len(batch):]
+		payload := graphitePickle(batch, now.Unix())
+		var header [4]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+		buf.Write(header[:])
+		buf.Write(payload)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// graphiteMetrics collects mon's finite stats as Graphite metric paths.
+func graphiteMetrics(mon Monitor, prefix string) (metrics []graphiteMetric) {
+	add := func(name string, tags map[string]string, val float64) {
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		metrics = append(metrics, graphiteMetric{
+			path: graphitePath(name, tags), val: val})
+	}
+	if tagged, ok := mon.(TaggedMonitor); ok {
+		tagged.TaggedStats(add)
+	} else {
+		mon.Stats(func(name string, val float64) { add(name, nil, val) })
+	}
+	return metrics
+}
+
+// graphitePath formats name and its tags in Graphite's tag syntax.
+func graphitePath(name string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys)+1)
+	parts = append(parts, name)
+	for _, key := range keys {
+		parts = append(parts, key+"="+tags[key])
+	}
+	return strings.Join(parts, ";")
+}
+
+// graphitePickle encodes metrics as a pickled list of
+// (path, (timestamp, value)) tuples, the format Carbon's pickle receiver
+// expects.
+func graphitePickle(metrics []graphiteMetric, timestamp int64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x80\x02") // PROTO 2
+	buf.WriteString("](")       // EMPTY_LIST, MARK
+	for _, metric := range metrics {
+		buf.WriteByte('X') // BINUNICODE
+		binary.Write(&buf, binary.LittleEndian, uint32(len(metric.path)))
+		buf.WriteString(metric.path)
+		buf.WriteByte('J') // BININT
+		binary.Write(&buf, binary.LittleEndian, int32(timestamp))
+		buf.WriteByte('G') // BINFLOAT
+		binary.Write(&buf, binary.BigEndian, metric.val)
+		buf.WriteString("\x86\x86") // TUPLE2, TUPLE2
+	}
+	buf.WriteString("e.") // APPENDS, STOP
+	return buf.Bytes()
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteGraphite(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("hits")
+	mon.WithTags(map[string]string{"method": "GET"}).EventNamed("reqs")
+
+	var buf bytes.Buffer
+	err := WriteGraphite(&buf, store, "app", time.Unix(1000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"app.foo.hits.count 1 1000\n",
+		"app.foo.reqs.count;method=GET 1 1000\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in output:\n%s", line, out)
+		}
+	}
+}
+
+func TestWriteGraphitePickle(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	mon.EventNamed("hits")
+
+	var buf bytes.Buffer
+	err := WriteGraphitePickle(&buf, mon, "", time.Unix(1000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if int(binary.BigEndian.Uint32(out)) != len(out)-4 {
+		t.Fatalf("bad length header in %q", out)
+	}
+	if !bytes.HasPrefix(out[4:], []byte("\x80\x02](X\x0e\x00\x00\x00foo.hits.count")) ||
+		!bytes.HasSuffix(out, []byte("\x86\x86e.")) {
+		t.Errorf("unexpected pickle %q", out)
+	}
+}
+
+func TestGraphiteExporter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	mon := NewMonitorGroup("foo")
+	mon.EventNamed("hits")
+	exporter, err := NewGraphiteExporter(mon, l.Addr().String(), "",
+		10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "foo.hits.count 1 ") {
+		t.Errorf("unexpected line %q", line)
+	}
+
+	// closing twice is harmless
+	exporter.Close()
+	exporter.Close()
+}
@@ -15,6 +15,10 @@
 package monitor
 
 import (
+	"strings"
+	"sync"
+	"time"
+
 	"gopkg.in/spacemonkeygo/monitor.v1/utils"
 )
 
@@ -25,6 +29,23 @@ type MonitorGroup struct {
 	group_name string
 	monitors   *utils.ThreadsafeCache
 	collectors *utils.ThreadsafeCache
+
+	// names_mtx guards names, the number of distinct names that monitors and
+	// collectors are stored under, along with the cache changes that move it.
+	names_mtx sync.Mutex
+	names     int
+
+	mtx            sync.Mutex
+	window         time.Duration
+	timing_buckets []float64
+	idle_expiry    time.Duration
+	last_expiry    time.Duration
+
+	store                *MonitorStore
+	cardinality_limit    int
+	cardinality_rejected uint64
+	statsd               *StatsDClient
+	classifier           ErrorClassifier
 }
 
 // NewMonitorGroup makes a new MonitorGroup unattached to anything.
@@ -35,3 +56,27 @@ func NewMonitorGroup(name string) *MonitorGroup {
 		collectors: utils.NewThreadsafeCache(),
 	}
 }
+
+// eachMonitor calls cb with each of the group's monitors in name order.
+func (g *MonitorGroup) eachMonitor(cb func(name string, mon Monitor)) {
+	snapshot := g.monitors.Snapshot()
+	for _, name := range sortedStringKeys(snapshot) {
+		if mon, ok := snapshot[name].(Monitor); ok {
+			cb(name, mon)
+		}
+	}
+}
+
+// shortCallerName trims the package path off of a CallerName, leaving the
+// function name Task and friends name their monitors after.
+func shortCallerName(caller_name string) string {
+	idx := strings.LastIndex(caller_name, "/")
+	if idx >= 0 {
+		caller_name = caller_name[idx+1:]
+	}
+	idx = strings.Index(caller_name, ".")
+	if idx >= 0 {
+		caller_name = caller_name[idx+1:]
+	}
+	return caller_name
+}
@@ -22,18 +22,37 @@ import (
 
 func (g *MonitorGroup) Stats(cb func(name string, val float64)) {}
 
+func (g *MonitorGroup) TaggedStats(
+	cb func(name string, tags map[string]string, val float64)) {
+}
+
 func (g *MonitorGroup) Running(cb func(name string, current []*TaskCtx)) {}
 
 func (g *MonitorGroup) Datapoints(reset bool, cb func(name string,
 	data [][]float64, total uint64, clipped bool, fraction float64)) {
 }
 
-func (self *MonitorGroup) Data(name string, val ...float64) {}
-func (self *MonitorGroup) Event(name string)                {}
-func (self *MonitorGroup) EventNamed(name string)           {}
-func (self *MonitorGroup) Val(name string, val float64)     {}
-func (self *MonitorGroup) IntVal(name string, val int64)    {}
-func (self *MonitorGroup) Chain(name string, other Monitor) {}
+func (self *MonitorGroup) Data(name string, val ...float64)   {}
+func (self *MonitorGroup) Event(name string)                  {}
+func (self *MonitorGroup) EventNamed(name string)             {}
+func (self *MonitorGroup) Mark(name string)                   {}
+func (self *MonitorGroup) MarkN(name string, n int64)         {}
+func (self *MonitorGroup) Val(name string, val float64)       {}
+func (self *MonitorGroup) IntVal(name string, val int64)      {}
+func (self *MonitorGroup) Quantiles(name string, val float64) {}
+func (self *MonitorGroup) Chain(name string, other Monitor)   {}
+
+func (self *MonitorGroup) ValDescribed(name, description string,
+	val float64) {
+}
+
+func (self *MonitorGroup) IntValDescribed(name, description string,
+	val int64) {
+}
+
+func (self *MonitorGroup) ChainDescribed(name, description string,
+	other Monitor) {
+}
 
 func (self *MonitorGroup) Task() func(*error)     { return func(*error) {} }
 func (self *MonitorGroup) DataTask() func(*error) { return func(*error) {} }
@@ -42,6 +61,36 @@ func (self *MonitorGroup) TaskNamed(name string) func(*error) {
 	return func(*error) {}
 }
 
+func (self *MonitorGroup) NewTask(name string) *TaskCtx { return &TaskCtx{} }
+
+func (self *MonitorGroup) DataTaskNamed(name string) func(*error) {
+	return func(*error) {}
+}
+
+func (self *MonitorGroup) NewDataTask(name string) *DataTaskCtx {
+	return &DataTaskCtx{}
+}
+
+func (self *MonitorGroup) TaskContext(ctx context.Context) func(*error) {
+	return func(*error) {}
+}
+
+func (self *MonitorGroup) TaskNamedContext(ctx context.Context,
+	name string) func(*error) {
+	return func(*error) {}
+}
+
+func (self *MonitorGroup) SetTaskLimit(name string, limit int) {}
+
+func (self *MonitorGroup) LimitedTaskNamed(ctx context.Context,
+	name string) (func(*error), error) {
+	return func(*error) {}, nil
+}
+
+func (self *MonitorGroup) TryTaskNamed(name string) (func(*error), error) {
+	return func(*error) {}, nil
+}
+
 func (self *MonitorGroup) TracedTask(*context.Context) func(*error) {
 	return func(*error) {}
 }
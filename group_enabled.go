@@ -18,7 +18,7 @@ package monitor
 
 import (
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/spacemonkeygo/errors"
 	"golang.org/x/net/context"
@@ -28,6 +28,7 @@ import (
 // Stats conforms to the Monitor interface. Stats aggregates all statistics
 // attatched to this group.
 func (g *MonitorGroup) Stats(cb func(name string, val float64)) {
+	g.expireIdle()
 	snapshot := g.monitors.Snapshot()
 	for _, name := range sortedStringKeys(snapshot) {
 		cache_val := snapshot[name]
@@ -41,6 +42,26 @@ func (g *MonitorGroup) Stats(cb func(name string, val float64)) {
 	}
 }
 
+// TaggedStats conforms to the TaggedMonitor interface. It reports the same
+// statistics as Stats, but monitors made through WithTags have their tags
+// passed to cb instead of flattened into the name.
+func (g *MonitorGroup) TaggedStats(
+	cb func(name string, tags map[string]string, val float64)) {
+	g.expireIdle()
+	snapshot := g.monitors.Snapshot()
+	for _, cache_key := range sortedStringKeys(snapshot) {
+		cache_val := snapshot[cache_key]
+		mon, ok := cache_val.(Monitor)
+		if !ok {
+			continue
+		}
+		name, tags := splitTaggedName(cache_key)
+		mon.Stats(func(subname string, val float64) {
+			cb(fmt.Sprintf("%s.%s.%s", g.group_name, name, subname), tags, val)
+		})
+	}
+}
+
 // Running collects lists of all running tasks by name
 func (g *MonitorGroup) Running(cb func(name string, current []*TaskCtx)) {
 	snapshot := g.monitors.Snapshot()
@@ -85,189 +106,491 @@ func (g *MonitorGroup) Datapoints(reset bool, cb func(name string,
 // N.B.: Error types are best tracked when you're using Space Monkey's
 // hierarchical error package: http://github.com/spacemonkeygo/errors
 func (self *MonitorGroup) Task() func(*error) {
-	caller_name := CallerName()
-	idx := strings.LastIndex(caller_name, "/")
-	if idx >= 0 {
-		caller_name = caller_name[idx+1:]
-	}
-	idx = strings.Index(caller_name, ".")
-	if idx >= 0 {
-		caller_name = caller_name[idx+1:]
-	}
-	return self.TaskNamed(caller_name)
+	return self.TaskNamed(shortCallerName(CallerName()))
 }
 
 // TaskNamed works just like Task without any automatic name selection
 func (self *MonitorGroup) TaskNamed(name string) func(*error) {
 	name = SanitizeName(name)
-	monitor, err := self.monitors.Get(name, func(_ interface{}) (interface{},
-		error) {
-		return NewTaskMonitor(), nil
-	})
-	if err != nil {
-		handleError(err)
+	task_monitor, name := self.taskMonitor(name)
+	if task_monitor == nil {
 		return func(*error) {}
 	}
-	task_monitor, ok := monitor.(*TaskMonitor)
-	if !ok {
-		handleError(errors.ProgrammerError.New(
-			"monitor already exists with different type for name %s", name))
+	return self.startTask(name, task_monitor, nil)
+}
+
+// NewTask starts a task like TaskNamed, but returns its TaskCtx, so that
+// values can be recorded on it with Record. TaskCtx.Done must be deferred
+// directly:
+//
+//	task := group.NewTask("query")
+//	defer task.Done(&err)
+//	...
+//	task.Record("rows", float64(len(rows)))
+func (self *MonitorGroup) NewTask(name string) *TaskCtx {
+	name = SanitizeName(name)
+	task_monitor, name := self.taskMonitor(name)
+	if task_monitor == nil {
+		return &TaskCtx{}
+	}
+	return self.beginTask(name, task_monitor, nil)
+}
+
+// TaskContext works like Task for a task that runs under ctx. Tasks that
+// fail because ctx was canceled or hit its deadline are counted as canceled
+// or deadline_exceeded rather than as errors, and the time left until ctx's
+// deadline is recorded when the task starts. See TaskMonitor.StartContext.
+func (self *MonitorGroup) TaskContext(ctx context.Context) func(*error) {
+	return self.TaskNamedContext(ctx, shortCallerName(CallerName()))
+}
+
+// TaskNamedContext works just like TaskContext without any automatic name
+// selection
+func (self *MonitorGroup) TaskNamedContext(ctx context.Context,
+	name string) func(*error) {
+	name = SanitizeName(name)
+	task_monitor, name := self.taskMonitor(name)
+	if task_monitor == nil {
 		return func(*error) {}
 	}
-	return task_monitor.Start()
+	return self.startTask(name, task_monitor, ctx)
 }
 
-// DataTask works just like Task, but automatically makes datapoints about
-// the task in question. It's a hybrid of Data and Task.
-func (self *MonitorGroup) DataTask() func(*error) {
-	// TODO: actually send data points
-	caller_name := CallerName()
-	idx := strings.LastIndex(caller_name, "/")
-	if idx >= 0 {
-		caller_name = caller_name[idx+1:]
+// SetTaskLimit sets the limit on how many tasks called name can run at once
+// when started with LimitedTaskNamed or TryTaskNamed. See
+// TaskMonitor.SetLimit. When built with no_mon, limits aren't enforced on
+// MonitorGroup tasks.
+func (self *MonitorGroup) SetTaskLimit(name string, limit int) {
+	task_monitor, _ := self.taskMonitor(SanitizeName(name))
+	if task_monitor != nil {
+		task_monitor.SetLimit(limit)
 	}
-	idx = strings.Index(caller_name, ".")
-	if idx >= 0 {
-		caller_name = caller_name[idx+1:]
+}
+
+// LimitedTaskNamed works like TaskNamedContext, but waits for the task's
+// limit to allow it to start, as TaskMonitor.StartLimited does. It returns
+// ctx's error if ctx is done first. ctx may be nil.
+//
+//	func (s *Server) Query(ctx context.Context) (err error) {
+//		done, err := mon.LimitedTaskNamed(ctx, "query")
+//		if err != nil {
+//			return err
+//		}
+//		defer done(&err)
+//		...
+//	}
+func (self *MonitorGroup) LimitedTaskNamed(ctx context.Context,
+	name string) (func(*error), error) {
+	return self.startLimitedTask(ctx, name, true)
+}
+
+// TryTaskNamed works like TaskNamed, but returns a LimitExceeded error
+// instead of starting the task if the task's limit is reached, as
+// TaskMonitor.TryStart does.
+func (self *MonitorGroup) TryTaskNamed(name string) (func(*error), error) {
+	return self.startLimitedTask(nil, name, false)
+}
+
+func (self *MonitorGroup) startLimitedTask(ctx context.Context, name string,
+	wait bool) (func(*error), error) {
+	name = SanitizeName(name)
+	task_monitor, name := self.taskMonitor(name)
+	if task_monitor == nil {
+		return func(*error) {}, nil
+	}
+	return task_monitor.startLimited(ctx, wait, func() *TaskCtx {
+		return self.beginTask(name, task_monitor, ctx)
+	})
+}
+
+// DataTask works just like Task, but also adds a datapoint about each run of
+// the task to a DatapointCollector by the same name. See DataTaskCtx for what
+// the datapoints hold.
+func (self *MonitorGroup) DataTask() func(*error) {
+	return self.DataTaskNamed(shortCallerName(CallerName()))
+}
+
+// DataTaskNamed works just like DataTask without any automatic name
+// selection
+func (self *MonitorGroup) DataTaskNamed(name string) func(*error) {
+	task := self.NewDataTask(name)
+	return func(e *error) { task.finish(e, recover()) }
+}
+
+// NewDataTask starts a task like DataTaskNamed, but returns a DataTaskCtx so
+// that the caller can add dimensions of its own to the datapoint before the
+// task finishes. DataTaskCtx.Finish must be deferred directly:
+//
+//	task := group.NewDataTask("upload")
+//	defer task.Finish(&err)
+//	...
+//	task.Add(float64(len(data)))
+func (self *MonitorGroup) NewDataTask(name string) *DataTaskCtx {
+	name = SanitizeName(name)
+	task := &DataTaskCtx{start: time.Now()}
+	task_monitor, stored_name := self.taskMonitor(name)
+	if task_monitor == nil {
+		return task
 	}
-	return self.TaskNamed(caller_name)
+	task.monitor = task_monitor
+	task.collector = self.datapointCollector(name)
+	task.end = self.beginTask(stored_name, task_monitor, nil).Finish
+	return task
 }
 
 // Data takes a name, makes a DataCollector if one doesn't exist, and adds
 // a datapoint to it.
 func (self *MonitorGroup) Data(name string, val ...float64) {
+	datapoint_collector := self.datapointCollector(SanitizeName(name))
+	if datapoint_collector != nil {
+		datapoint_collector.Add(val...)
+	}
+}
+
+// Event simply calls EventNamed after adding a prefix to the name based on
+// the caller.
+func (self *MonitorGroup) Event(name string) {
+	self.EventNamed(shortCallerName(CallerName()) + "." + name)
+}
+
+// EventNamed creates an EventMonitor by the given name if one doesn't exist
+// and adds an event to it.
+func (self *MonitorGroup) EventNamed(name string) {
+	name = SanitizeName(name)
+	event_monitor, name := self.eventMonitor(name)
+	if event_monitor != nil {
+		event_monitor.Add()
+		self.forwardCount(name, 1)
+	}
+}
+
+// Mark creates a MeterMonitor by the given name if one doesn't exist and
+// marks one event on it.
+func (self *MonitorGroup) Mark(name string) {
+	self.MarkN(name, 1)
+}
+
+// MarkN works like Mark, but marks n events at once.
+func (self *MonitorGroup) MarkN(name string, n int64) {
+	name = SanitizeName(name)
+	meter_monitor, name := self.meterMonitor(name)
+	if meter_monitor != nil {
+		meter_monitor.Mark(n)
+		self.forwardCount(name, n)
+	}
+}
+
+// Val creates a ValueMonitor by the given name if one doesn't exist and adds
+// a value to it.
+func (self *MonitorGroup) Val(name string, val float64) {
+	self.ValDescribed(name, "", val)
+}
+
+// ValDescribed works like Val, but if it creates the ValueMonitor, the
+// monitor gets description as the help text of its stats, which exporters
+// such as WritePrometheus use in place of the monitor's own.
+func (self *MonitorGroup) ValDescribed(name, description string, val float64) {
+	name = SanitizeName(name)
+	val_monitor, name := self.valueMonitor(name, description)
+	if val_monitor != nil {
+		val_monitor.Add(val)
+		self.forwardGauge(name, val)
+	}
+}
+
+// Quantiles creates a QuantileMonitor by the given name if one doesn't exist
+// and adds a value to it. Use it instead of Val when you need percentiles.
+func (self *MonitorGroup) Quantiles(name string, val float64) {
+	quantile_monitor := self.quantileMonitor(SanitizeName(name))
+	if quantile_monitor != nil {
+		quantile_monitor.Add(val)
+	}
+}
+
+// IntVal is faster than Val when you don't want to deal with floating point
+// ops.
+func (self *MonitorGroup) IntVal(name string, val int64) {
+	self.IntValDescribed(name, "", val)
+}
+
+// IntValDescribed works like IntVal, but if it creates the IntValueMonitor,
+// the monitor gets description as the help text of its stats.
+func (self *MonitorGroup) IntValDescribed(name, description string,
+	val int64) {
+	name = SanitizeName(name)
+	val_monitor, name := self.intValueMonitor(name, description)
+	if val_monitor != nil {
+		val_monitor.Add(val)
+		self.forwardGauge(name, float64(val))
+	}
+}
+
+// Chain creates a ChainedMonitor by the given name if one doesn't exist and
+// sets the Monitor other to it.
+func (self *MonitorGroup) Chain(name string, other Monitor) {
+	self.ChainDescribed(name, "", other)
+}
+
+// ChainDescribed works like Chain, but if it creates the ChainedMonitor, the
+// monitor gets description as the help text of the chained monitor's stats.
+func (self *MonitorGroup) ChainDescribed(name, description string,
+	other Monitor) {
 	name = SanitizeName(name)
-	monitor, err := self.collectors.Get(name, func(_ interface{}) (interface{},
-		error) {
-		return NewDatapointCollector(Config.DefaultCollectionFraction,
-			Config.DefaultCollectionMax), nil
+	monitor, _ := self.monitor(name, "", func() interface{} {
+		return &ChainedMonitor{description: description}
 	})
-	if err != nil {
-		handleError(err)
+	if monitor == nil {
 		return
 	}
-	datapoint_collector, ok := monitor.(*DatapointCollector)
+	chain_monitor, ok := monitor.(*ChainedMonitor)
 	if !ok {
 		handleError(errors.ProgrammerError.New(
 			"monitor already exists with different type for name %s", name))
 		return
 	}
-	datapoint_collector.Add(val...)
+	chain_monitor.Set(other)
 }
 
-// Event simply calls EventNamed after adding a prefix to the name based on
-// the caller.
-func (self *MonitorGroup) Event(name string) {
-	caller_name := CallerName()
-	idx := strings.LastIndex(caller_name, "/")
-	if idx >= 0 {
-		caller_name = caller_name[idx+1:]
+// monitor finds or creates the monitor stored under name, which must already
+// be sanitized, using create to make a new one. It returns the monitor and
+// the name it's actually stored under, or nil if the monitor couldn't be
+// created.
+//
+// Names that are new to the group count against its cardinality limits, and
+// kind names the overflow monitor to use when a limit is reached. Monitors
+// with no kind are exempt from the limits.
+func (self *MonitorGroup) monitor(name, kind string,
+	create func() interface{}) (interface{}, string) {
+	if monitor, ok := self.monitors.Lookup(name); ok {
+		return monitor, name
+	}
+	if kind != "" && self.atCardinalityLimit() {
+		name = self.rejectName(name, kind)
+	}
+	monitor, created, err := self.create(self.monitors, self.collectors,
+		name, create)
+	if err != nil {
+		handleError(err)
+		return nil, name
 	}
-	idx = strings.Index(caller_name, ".")
-	if idx >= 0 {
-		caller_name = caller_name[idx+1:]
+	if created {
+		self.expireIdle()
 	}
-	self.EventNamed(caller_name + "." + name)
+	return monitor, name
 }
 
-// EventNamed creates an EventMonitor by the given name if one doesn't exist
-// and adds an event to it.
-func (self *MonitorGroup) EventNamed(name string) {
-	name = SanitizeName(name)
-	monitor, err := self.monitors.Get(name, func(_ interface{}) (interface{},
-		error) {
-		return NewEventMonitor(), nil
+func (self *MonitorGroup) taskMonitor(name string) (*TaskMonitor, string) {
+	monitor, name := self.monitor(name, "task", func() interface{} {
+		var task_monitor *TaskMonitor
+		bounds := self.getTimingBuckets()
+		if window := self.getWindow(); window > 0 {
+			task_monitor = NewWindowedTaskMonitorWithBuckets(window, bounds)
+		} else {
+			task_monitor = NewTaskMonitorWithBuckets(bounds)
+		}
+		task_monitor.classifier = self.getErrorClassifier()
+		return task_monitor
 	})
-	if err != nil {
-		handleError(err)
-		return
+	if monitor == nil {
+		return nil, name
+	}
+	task_monitor, ok := monitor.(*TaskMonitor)
+	if !ok {
+		handleError(errors.ProgrammerError.New(
+			"monitor already exists with different type for name %s", name))
+		return nil, name
+	}
+	return task_monitor, name
+}
+
+func (self *MonitorGroup) eventMonitor(name string) (*EventMonitor, string) {
+	monitor, name := self.monitor(name, "event", func() interface{} {
+		return NewEventMonitor()
+	})
+	if monitor == nil {
+		return nil, name
 	}
 	event_monitor, ok := monitor.(*EventMonitor)
 	if !ok {
 		handleError(errors.ProgrammerError.New(
 			"monitor already exists with different type for name %s", name))
-		return
+		return nil, name
 	}
-	event_monitor.Add()
+	return event_monitor, name
 }
 
-// Val creates a ValueMonitor by the given name if one doesn't exist and adds
-// a value to it.
-func (self *MonitorGroup) Val(name string, val float64) {
-	name = SanitizeName(name)
-	monitor, err := self.monitors.Get(name, func(_ interface{}) (interface{},
-		error) {
-		return NewValueMonitor(), nil
+func (self *MonitorGroup) meterMonitor(name string) (*MeterMonitor, string) {
+	monitor, name := self.monitor(name, "meter", func() interface{} {
+		return NewMeterMonitor()
 	})
-	if err != nil {
-		handleError(err)
-		return
+	if monitor == nil {
+		return nil, name
 	}
-	val_monitor, ok := monitor.(*ValueMonitor)
+	meter_monitor, ok := monitor.(*MeterMonitor)
 	if !ok {
 		handleError(errors.ProgrammerError.New(
 			"monitor already exists with different type for name %s", name))
-		return
+		return nil, name
 	}
-	val_monitor.Add(val)
+	return meter_monitor, name
 }
 
-// IntVal is faster than Val when you don't want to deal with floating point
-// ops.
-func (self *MonitorGroup) IntVal(name string, val int64) {
-	name = SanitizeName(name)
-	monitor, err := self.monitors.Get(name, func(_ interface{}) (interface{},
-		error) {
-		return NewIntValueMonitor(), nil
+func (self *MonitorGroup) valueMonitor(name, description string) (
+	valueAdder, string) {
+	monitor, name := self.monitor(name, "value", func() interface{} {
+		if window := self.getWindow(); window > 0 {
+			val_monitor := NewWindowedValueMonitor(window)
+			val_monitor.description = description
+			return val_monitor
+		}
+		val_monitor := NewValueMonitor()
+		val_monitor.description = description
+		return val_monitor
 	})
-	if err != nil {
-		handleError(err)
-		return
+	if monitor == nil {
+		return nil, name
+	}
+	switch val_monitor := monitor.(type) {
+	case *ValueMonitor:
+		return val_monitor, name
+	case *WindowedValueMonitor:
+		return val_monitor, name
+	}
+	handleError(errors.ProgrammerError.New(
+		"monitor already exists with different type for name %s", name))
+	return nil, name
+}
+
+func (self *MonitorGroup) intValueMonitor(name, description string) (
+	intValueAdder, string) {
+	monitor, name := self.monitor(name, "int_value", func() interface{} {
+		if window := self.getWindow(); window > 0 {
+			val_monitor := NewWindowedIntValueMonitor(window)
+			val_monitor.description = description
+			return val_monitor
+		}
+		val_monitor := NewIntValueMonitor()
+		val_monitor.description = description
+		return val_monitor
+	})
+	if monitor == nil {
+		return nil, name
+	}
+	switch val_monitor := monitor.(type) {
+	case *IntValueMonitor:
+		return val_monitor, name
+	case *WindowedIntValueMonitor:
+		return val_monitor, name
 	}
-	val_monitor, ok := monitor.(*IntValueMonitor)
+	handleError(errors.ProgrammerError.New(
+		"monitor already exists with different type for name %s", name))
+	return nil, name
+}
+
+func (self *MonitorGroup) quantileMonitor(name string) *QuantileMonitor {
+	monitor, _ := self.monitor(name, "quantile", func() interface{} {
+		return NewQuantileMonitor()
+	})
+	if monitor == nil {
+		return nil
+	}
+	quantile_monitor, ok := monitor.(*QuantileMonitor)
 	if !ok {
 		handleError(errors.ProgrammerError.New(
 			"monitor already exists with different type for name %s", name))
-		return
+		return nil
 	}
-	val_monitor.Add(val)
+	return quantile_monitor
 }
 
-// Chain creates a ChainedMonitor by the given name if one doesn't exist and
-// sets the Monitor other to it.
-func (self *MonitorGroup) Chain(name string, other Monitor) {
-	name = SanitizeName(name)
-	monitor, err := self.monitors.Get(
-		name, func(_ interface{}) (interface{}, error) {
-			return NewChainedMonitor(), nil
+// startTask starts a task on task_monitor, which is stored under name, under
+// ctx if it isn't nil.
+func (self *MonitorGroup) startTask(name string, task_monitor *TaskMonitor,
+	ctx context.Context) func(*error) {
+	task_ctx := self.beginTask(name, task_monitor, ctx)
+	return func(e *error) { task_ctx.Finish(e, recover()) }
+}
+
+// beginTask works like startTask, but returns the TaskCtx. If the group has a
+// StatsD client, the task's duration and failure are forwarded to it when the
+// task finishes.
+func (self *MonitorGroup) beginTask(name string, task_monitor *TaskMonitor,
+	ctx context.Context) *TaskCtx {
+	task_ctx := task_monitor.newContext(ctx)
+	client := self.getStatsD()
+	if client == nil {
+		return task_ctx
+	}
+	statsd_name, tags := self.statsdName(name)
+	task_ctx.on_finish = func(e *error, rec interface{}) {
+		client.Timing(statsd_name, tags, task_ctx.ElapsedTime())
+		if rec != nil || (e != nil && *e != nil &&
+			contextErrorName(ctx, *e) == "") {
+			client.Count(statsd_name+".errors", tags, 1)
+		}
+	}
+	return task_ctx
+}
+
+// forwardCount sends a counter increment for the monitor stored under name to
+// the group's StatsD client, if it has one.
+func (self *MonitorGroup) forwardCount(name string, n int64) {
+	if client := self.getStatsD(); client != nil {
+		statsd_name, tags := self.statsdName(name)
+		client.Count(statsd_name, tags, n)
+	}
+}
+
+// forwardGauge works like forwardCount, but sends a gauge value.
+func (self *MonitorGroup) forwardGauge(name string, val float64) {
+	if client := self.getStatsD(); client != nil {
+		statsd_name, tags := self.statsdName(name)
+		client.Gauge(statsd_name, tags, val)
+	}
+}
+
+// datapointCollector finds or creates the DatapointCollector stored under
+// name, which must already be sanitized. It returns nil on failure. New names
+// count against the group's cardinality limits like in monitor.
+func (self *MonitorGroup) datapointCollector(name string) *DatapointCollector {
+	if monitor, ok := self.collectors.Lookup(name); ok {
+		return self.asDatapointCollector(name, monitor)
+	}
+	if self.atCardinalityLimit() {
+		name = self.rejectName(name, "data")
+	}
+	monitor, created, err := self.create(self.collectors, self.monitors,
+		name, func() interface{} {
+			return NewDatapointCollector(Config.DefaultCollectionFraction,
+				Config.DefaultCollectionMax)
 		})
 	if err != nil {
 		handleError(err)
-		return
+		return nil
 	}
-	chain_monitor, ok := monitor.(*ChainedMonitor)
+	if created {
+		self.expireIdle()
+	}
+	return self.asDatapointCollector(name, monitor)
+}
+
+func (self *MonitorGroup) asDatapointCollector(name string,
+	monitor interface{}) *DatapointCollector {
+	datapoint_collector, ok := monitor.(*DatapointCollector)
 	if !ok {
 		handleError(errors.ProgrammerError.New(
 			"monitor already exists with different type for name %s", name))
-		return
+		return nil
 	}
-	chain_monitor.Set(other)
+	return datapoint_collector
 }
 
 // TracedTask creates a Task and also uses
 // gopkg.in/spacemonkeygo/monitor.v1/trace's Trace function to Trace the given
 // function. Currently only uses the default tracing SpanManager
 func (self *MonitorGroup) TracedTask(ctx *context.Context) func(*error) {
-	caller_name := CallerName()
-	trace_caller_name := caller_name
-	idx := strings.LastIndex(caller_name, "/")
-	if idx >= 0 {
-		caller_name = caller_name[idx+1:]
-	}
-	idx = strings.Index(caller_name, ".")
-	if idx >= 0 {
-		caller_name = caller_name[idx+1:]
-	}
+	trace_caller_name := CallerName()
+	caller_name := shortCallerName(trace_caller_name)
 	task_defer := self.TaskNamed(caller_name)
 	trace_defer := trace.TraceWithSpanNamed(ctx, trace_caller_name)
 
@@ -294,3 +617,4 @@ func (self *MonitorGroup) TracedTask(ctx *context.Context) func(*error) {
 }
 
 var _ RunningTasksCollector = (*MonitorGroup)(nil)
+var _ TaggedMonitor = (*MonitorGroup)(nil)
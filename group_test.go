@@ -28,3 +28,15 @@ func ExampleMonitorGroup_Task(t *testing.T) {
 	}
 	myfunc()
 }
+
+func TestShortCallerName(t *testing.T) {
+	for caller_name, expected := range map[string]string{
+		"gopkg.in/spacemonkeygo/monitor%2ev1.TestTask": "TestTask",
+		"main.(*Server).handle":                        "(*Server).handle",
+		"handle":                                       "handle",
+	} {
+		if name := shortCallerName(caller_name); name != expected {
+			t.Errorf("%s: got %q, want %q", caller_name, name, expected)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// HistogramQuantiles are the quantiles a Histogram reports. QuantileMonitors
+	// report the same ones.
+	HistogramQuantiles = []float64{.5, .9, .99, .999}
+)
+
+// DefaultTimingBuckets returns histogram bucket upper bounds, in seconds,
+// that suit task timings from 100µs to 100s. See SetTimingBuckets.
+func DefaultTimingBuckets() []float64 {
+	return []float64{
+		.0001, .0002, .0005, .001, .002, .005, .01, .02, .05,
+		.1, .2, .5, 1, 2, 5, 10, 20, 50, 100}
+}
+
+// ExponentialBuckets returns count histogram bucket upper bounds, starting at
+// start and growing by factor each time.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	bounds := make([]float64, 0, count)
+	for i := 0; i < count; i++ {
+		bounds = append(bounds, start)
+		start *= factor
+	}
+	return bounds
+}
+
+// Histogram counts values into fixed buckets, and estimates quantiles from
+// those counts.
+type Histogram struct {
+	mtx    sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	max    float64
+	min    float64
+}
+
+// NewHistogram makes a Histogram with the given bucket upper bounds. An
+// overflow bucket for values larger than the largest bound is always added.
+func NewHistogram(bounds []float64) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	return &Histogram{
+		bounds: sorted,
+		counts: make([]uint64, len(sorted)+1),
+		max:    math.Inf(-1),
+		min:    math.Inf(1)}
+}
+
+// Add counts val into its bucket
+func (h *Histogram) Add(val float64) {
+	idx := sort.SearchFloat64s(h.bounds, val)
+	h.mtx.Lock()
+	h.counts[idx] += 1
+	h.count += 1
+	if val > h.max {
+		h.max = val
+	}
+	if val < h.min {
+		h.min = val
+	}
+	h.mtx.Unlock()
+}
+
+// Quantile estimates the value below which the fraction q of all values
+// fall, interpolating linearly within the bucket that contains it.
+func (h *Histogram) Quantile(q float64) float64 {
+	h.mtx.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, max, min := h.count, h.max, h.min
+	h.mtx.Unlock()
+	return h.quantile(q, counts, count, max, min)
+}
+
+func (h *Histogram) quantile(q float64, counts []uint64, count uint64,
+	max, min float64) float64 {
+	if count == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(count)
+	var seen uint64
+	for idx, bucket_count := range counts {
+		if bucket_count == 0 || float64(seen+bucket_count) < rank {
+			seen += bucket_count
+			continue
+		}
+		lower, upper := min, max
+		if idx > 0 && h.bounds[idx-1] > lower {
+			lower = h.bounds[idx-1]
+		}
+		if idx < len(h.bounds) && h.bounds[idx] < upper {
+			upper = h.bounds[idx]
+		}
+		return lower + (upper-lower)*(rank-float64(seen))/float64(bucket_count)
+	}
+	return max
+}
+
+// Stats conforms to the Monitor interface. Buckets are reported cumulatively,
+// named by their upper bound, like bucket_le_0_005 or bucket_le_inf.
+func (h *Histogram) Stats(cb func(name string, val float64)) {
+	h.mtx.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, max, min := h.count, h.max, h.min
+	h.mtx.Unlock()
+	h.stats(counts, count, max, min, cb)
+}
+
+func (h *Histogram) stats(counts []uint64, count uint64, max, min float64,
+	cb func(name string, val float64)) {
+	var cumulative uint64
+	for idx, bucket_count := range counts {
+		cumulative += bucket_count
+		cb(fmt.Sprintf("bucket_le_%s", h.boundName(idx)), float64(cumulative))
+	}
+	cb("count", float64(count))
+	if count > 0 {
+		for _, q := range HistogramQuantiles {
+			cb(quantileName(q), h.quantile(q, counts, count, max, min))
+		}
+	}
+}
+
+func (h *Histogram) boundName(idx int) string {
+	if idx >= len(h.bounds) {
+		return "inf"
+	}
+	return strings.Replace(
+		strconv.FormatFloat(h.bounds[idx], 'f', -1, 64), ".", "_", -1)
+}
+
+// quantileName names a quantile as a percentile, e.g. .99 becomes p99 and
+// .999 becomes p999.
+func quantileName(q float64) string {
+	digits := strings.TrimPrefix(strconv.FormatFloat(q, 'f', -1, 64), "0.")
+	for len(digits) < 2 {
+		digits += "0"
+	}
+	return "p" + digits
+}
+
+// SetTimingBuckets makes the TaskMonitors that this MonitorGroup creates from
+// now on keep timing histograms with the given bucket upper bounds, in
+// seconds, and report bucket counts and percentiles from them. Task monitors
+// that already exist keep their current buckets. Empty bounds turn histograms
+// back off, which is the default.
+func (self *MonitorGroup) SetTimingBuckets(bounds []float64) {
+	bounds = append([]float64(nil), bounds...)
+	self.mtx.Lock()
+	self.timing_buckets = bounds
+	self.mtx.Unlock()
+}
+
+func (self *MonitorGroup) getTimingBuckets() []float64 {
+	self.mtx.Lock()
+	bounds := self.timing_buckets
+	self.mtx.Unlock()
+	return bounds
+}
+
+// SetTimingBuckets calls SetTimingBuckets on every MonitorGroup in the store,
+// and on every MonitorGroup the store creates afterwards.
+func (s *MonitorStore) SetTimingBuckets(bounds []float64) {
+	bounds = append([]float64(nil), bounds...)
+	s.mtx.Lock()
+	s.timing_buckets = bounds
+	s.mtx.Unlock()
+	s.eachGroup(func(group *MonitorGroup) {
+		group.SetTimingBuckets(bounds)
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(ExponentialBuckets(1, 10, 4))
+	for i := 1; i <= 1000; i++ {
+		h.Add(float64(i))
+	}
+
+	stats := Collect(h)
+	for name, val := range map[string]float64{
+		"bucket_le_1":    1,
+		"bucket_le_10":   10,
+		"bucket_le_100":  100,
+		"bucket_le_1000": 1000,
+		"bucket_le_inf":  1000,
+		"count":          1000,
+	} {
+		if stats[name] != val {
+			t.Errorf("%s: got %f, want %f", name, stats[name], val)
+		}
+	}
+
+	for q, want := range map[float64]float64{.5: 500, .9: 900, .99: 990} {
+		if got := h.Quantile(q); math.Abs(got-want) > 1 {
+			t.Errorf("quantile %f: got %f, want %f", q, got, want)
+		}
+	}
+	if _, ok := stats["p999"]; !ok {
+		t.Errorf("missing p999 in %v", stats)
+	}
+}
+
+func TestQuantileName(t *testing.T) {
+	for q, name := range map[float64]string{
+		.5: "p50", .9: "p90", .99: "p99", .999: "p999", .05: "p05"} {
+		if got := quantileName(q); got != name {
+			t.Errorf("quantileName(%f) = %q, want %q", q, got, name)
+		}
+	}
+}
+
+func TestTimingBuckets(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	mon.TaskNamed("plain")(nil)
+	mon.SetTimingBuckets(DefaultTimingBuckets())
+	mon.TaskNamed("bucketed")(nil)
+
+	stats := Collect(mon)
+	if _, ok := stats["foo.plain.time_total_p99"]; ok {
+		t.Errorf("histograms should be off by default")
+	}
+	if stats["foo.bucketed.time_total_bucket_le_inf"] != 1 {
+		t.Errorf("missing histogram in %v", stats)
+	}
+}
@@ -17,63 +17,138 @@ package monitor
 import (
 	"fmt"
 	"net/http"
-	"sort"
-	"strings"
-	"time"
 )
 
-type sortableTask struct {
-	name    string
-	elapsed time.Duration
-}
+// ServeHTTP dumps all of the MonitorStore's keys and values to the requester.
+// This method allows a MonitorStore to be registered as an HTTP handler.
+//
+// Requests for a path ending in "running" or "datapoints" get the running
+// tasks or datapoints instead of the stats. A path ending in "/stats/<group>",
+// "/running/<group>" or "/datapoints/<group>" only walks the MonitorGroup
+// named group. A filter query parameter limits the output to names that
+// start with it, that match it as a glob if it has any of "*?[", or that
+// match it as a regular expression if it's wrapped in slashes, such as
+// ?filter=/error_/.
+//
+// Requests for a path ending in "stacks" get the current stack trace of each
+// task that has been running for longer than the threshold query parameter,
+// a duration that defaults to DefaultStackThreshold. See WriteStacks.
+//
+// Requests for a path ending in "dashboard", or with a format=html query
+// parameter, get an HTML dashboard with sparklines of recent history that
+// reloads itself every refresh query parameter seconds (DashboardRefresh by
+// default, and 0 for never). "/dashboard/<group>" and filter work as above.
+//
+// Requests for a path ending in "metrics", or that accept the Prometheus or
+// OpenMetrics text formats, get a Prometheus exposition instead. Requests with
+// a format=json query parameter or a JSON Accept header get the stats,
+// running tasks or datapoints as JSON.
+func (s *MonitorStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if prometheus, openmetrics := prometheusFormat(req); prometheus &&
+		!jsonFormat(req) {
+		if openmetrics {
+			w.Header().Set("Content-Type", openMetricsContentType)
+			s.WriteOpenMetrics(w)
+		} else {
+			w.Header().Set("Content-Type", prometheusContentType)
+			s.WritePrometheus(w)
+		}
+		return
+	}
 
-type sortableTasks []sortableTask
+	view, err := parseStoreView(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-func (s sortableTasks) Len() int      { return len(s) }
-func (s sortableTasks) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+	if view.kind == viewDashboard || dashboardFormat(req) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		found, err := s.writeDashboard(w, view, dashboardRefresh(req))
+		if err != nil {
+			handleError(err)
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("no monitor group named %q", view.group),
+				http.StatusNotFound)
+		}
+		return
+	}
 
-func (s sortableTasks) Less(i, j int) bool {
-	return s[i].elapsed < s[j].elapsed
-}
+	if view.kind == viewStacks {
+		threshold, err := stackThreshold(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		found, err := s.writeStacks(w, view, threshold)
+		if err != nil {
+			handleError(err)
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("no monitor group named %q", view.group),
+				http.StatusNotFound)
+		}
+		return
+	}
 
-// ServeHTTP dumps all of the MonitorStore's keys and values to the requester.
-// This method allows a MonitorStore to be registered as an HTTP handler.
-func (s *MonitorStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
+	var stats []Stat
+	var running []RunningTask
+	var datasets []Dataset
+	var found bool
+	switch view.kind {
+	case viewRunning:
+		running, found = view.running(s)
+	case viewDatapoints:
+		datasets, found = view.datasets(s)
+	default:
+		stats, found = view.stats(s)
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("no monitor group named %q", view.group),
+			http.StatusNotFound)
+		return
+	}
 
-	if strings.HasSuffix(req.URL.Path, "running") {
-		var tasks []sortableTask
-		s.Running(func(name string, current []*TaskCtx) {
-			for _, task := range current {
-				tasks = append(tasks, sortableTask{
-					name:    name,
-					elapsed: task.ElapsedTime()})
-			}
-		})
-		sort.Sort(sort.Reverse(sortableTasks(tasks)))
-		for _, task := range tasks {
-			fmt.Fprintf(w, "%s\t%s\n", task.elapsed, task.name)
+	if jsonFormat(req) {
+		w.Header().Set("Content-Type", jsonContentType)
+		switch view.kind {
+		case viewRunning:
+			err = writeJSON(w, newJSONRunning(running))
+		case viewDatapoints:
+			err = writeJSON(w, newJSONDatapoints(datasets))
+		default:
+			err = writeJSON(w, newJSONStats(stats))
+		}
+		if err != nil {
+			handleError(err)
 		}
 		return
 	}
 
-	if strings.HasSuffix(req.URL.Path, "datapoints") {
-		s.Datapoints(false, func(key string, data [][]float64,
-			total uint64, clipped bool, fraction float64) {
+	w.Header().Set("Content-Type", "text/plain")
 
-			fmt.Fprintf(w, "%s\t%d\t%v\t%f\n", key, total, clipped, fraction)
-			for idx, points := range data {
+	switch view.kind {
+	case viewRunning:
+		for _, task := range running {
+			fmt.Fprintf(w, "%s\t%s\n", task.Elapsed, task.flatName())
+		}
+	case viewDatapoints:
+		for _, dataset := range datasets {
+			fmt.Fprintf(w, "%s\t%d\t%v\t%f\n", dataset.flatName(),
+				dataset.Total, dataset.Clipped, dataset.Fraction)
+			for idx, points := range dataset.Data {
 				fmt.Fprintf(w, "\t%v", points)
 				if (idx+1)%6 == 0 {
 					fmt.Fprintln(w)
 				}
 			}
 			fmt.Fprintln(w)
-		})
-		return
+		}
+	default:
+		for _, stat := range stats {
+			fmt.Fprintf(w, "%s\t%f\n", stat.flatName(), stat.Val)
+		}
 	}
-
-	s.Stats(func(name string, val float64) {
-		fmt.Fprintf(w, "%s\t%f\n", name, val)
-	})
 }
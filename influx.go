@@ -0,0 +1,257 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// InfluxHTTPBatchLines is how many points an InfluxWriter made with
+	// NewInfluxHTTPWriter sends per HTTP request.
+	InfluxHTTPBatchLines = 5000
+
+	// InfluxUDPPacketSize is the largest UDP payload an InfluxWriter made
+	// with NewInfluxUDPWriter sends.
+	InfluxUDPPacketSize = 1432
+
+	// InfluxHTTPTimeout is how long an InfluxWriter made with
+	// NewInfluxHTTPWriter and no http.Client waits for each request.
+	InfluxHTTPTimeout = 30 * time.Second
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(
+		",", `\,`, " ", `\ `)
+	influxKeyEscaper = strings.NewReplacer(
+		",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// WriteInflux writes all of the MonitorStore's statistics to w in the
+// InfluxDB line protocol, as one point per monitor. The group name is the
+// point's measurement, the monitor name is its monitor tag, any tags from a
+// TaggedMonitorGroup are additional tags, and the monitor's statistics (avg,
+// count, max, and so on) are its fields. The monitor tag is reserved, so a
+// TaggedMonitorGroup tag named monitor is written as tag_monitor instead.
+// Values that aren't finite are skipped.
+func (s *MonitorStore) WriteInflux(w io.Writer, now time.Time) error {
+	var buf bytes.Buffer
+	for _, line := range influxLines(s.collectStats(), now) {
+		buf.Write(line)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// influxLines returns stats as newline terminated lines, one per monitor.
+func influxLines(stats []Stat, now time.Time) (lines [][]byte) {
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	var fields []string
+	for i, stat := range stats {
+		if !math.IsNaN(stat.Val) && !math.IsInf(stat.Val, 0) {
+			fields = append(fields, influxKeyEscaper.Replace(stat.Stat)+"="+
+				strconv.FormatFloat(stat.Val, 'g', -1, 64))
+		}
+		if i+1 < len(stats) && sameMonitor(stat, stats[i+1]) {
+			continue
+		}
+		if len(fields) > 0 {
+			lines = append(lines, influxLine(stat.Group, stat.Monitor,
+				stat.Tags, fields, timestamp))
+		}
+		fields = nil
+	}
+	return lines
+}
+
+// sameMonitor returns whether a and b came from the same monitor.
+func sameMonitor(a, b Stat) bool {
+	return a.Group == b.Group &&
+		taggedName(a.Monitor, a.Tags) == taggedName(b.Monitor, b.Tags)
+}
+
+func influxLine(measurement, monitor_name string, tags map[string]string,
+	fields []string, timestamp string) []byte {
+	all_tags := make(map[string]string, len(tags)+1)
+	for key, val := range tags {
+		if key == "monitor" {
+			key = "tag_monitor"
+		}
+		all_tags[key] = val
+	}
+	all_tags["monitor"] = monitor_name
+	keys := make([]string, 0, len(all_tags))
+	for key := range all_tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString(influxMeasurementEscaper.Replace(measurement))
+	for _, key := range keys {
+		buf.WriteByte(',')
+		buf.WriteString(influxKeyEscaper.Replace(key))
+		buf.WriteByte('=')
+		buf.WriteString(influxKeyEscaper.Replace(all_tags[key]))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strings.Join(fields, ","))
+	buf.WriteByte(' ')
+	buf.WriteString(timestamp)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// InfluxWriter sends a MonitorStore's statistics to InfluxDB in batches,
+// over either HTTP or UDP.
+type InfluxWriter struct {
+	send      func(ctx context.Context, batch []byte) error
+	max_lines int
+	max_bytes int
+	done      chan struct{}
+	closing   sync.Once
+}
+
+// NewInfluxHTTPWriter creates an InfluxWriter that posts to write_url, which
+// should be InfluxDB's full write endpoint, such as
+// "http://127.0.0.1:8086/write?db=metrics". A nil client means a client
+// that gives up on requests after InfluxHTTPTimeout.
+func NewInfluxHTTPWriter(write_url string, client *http.Client) *InfluxWriter {
+	if client == nil {
+		client = &http.Client{Timeout: InfluxHTTPTimeout}
+	}
+	return &InfluxWriter{
+		send: func(ctx context.Context, batch []byte) error {
+			req, err := http.NewRequest("POST", write_url,
+				bytes.NewReader(batch))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "text/plain")
+			resp, err := client.Do(req.WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+				return Error.New("influxdb write failed: %s: %s",
+					resp.Status, bytes.TrimSpace(body))
+			}
+			return nil
+		},
+		max_lines: InfluxHTTPBatchLines,
+		done:      make(chan struct{})}
+}
+
+// NewInfluxUDPWriter creates an InfluxWriter that sends to InfluxDB's UDP
+// listener at influx_addr.
+func NewInfluxUDPWriter(influx_addr string) (*InfluxWriter, error) {
+	addr, err := net.ResolveUDPAddr("udp", influx_addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	w := &InfluxWriter{
+		send: func(ctx context.Context, batch []byte) error {
+			// a zero deadline, when ctx has none, clears any earlier one
+			deadline, _ := ctx.Deadline()
+			if err := conn.SetWriteDeadline(deadline); err != nil {
+				return err
+			}
+			_, err := conn.Write(batch)
+			return err
+		},
+		max_bytes: InfluxUDPPacketSize,
+		done:      make(chan struct{})}
+	go func() {
+		<-w.done
+		conn.Close()
+	}()
+	return w, nil
+}
+
+// Write sends all of s's statistics, timestamped with now, as in
+// MonitorStore.WriteInflux. It stops at the first batch that fails.
+func (w *InfluxWriter) Write(s *MonitorStore, now time.Time) error {
+	return w.write(context.Background(), s.collectStats(), now)
+}
+
+// Send conforms to the Sink interface, so an InfluxWriter can be added to a
+// Reporter. Requests and writes are abandoned once ctx is done.
+func (w *InfluxWriter) Send(ctx context.Context, report *Report) error {
+	return w.write(ctx, report.Points, report.Time)
+}
+
+func (w *InfluxWriter) write(ctx context.Context, stats []Stat,
+	now time.Time) error {
+	var batch []byte
+	lines := 0
+	for _, line := range influxLines(stats, now) {
+		if lines > 0 && ((w.max_lines > 0 && lines >= w.max_lines) ||
+			(w.max_bytes > 0 && len(batch)+len(line) > w.max_bytes)) {
+			if err := w.send(ctx, batch); err != nil {
+				return err
+			}
+			batch, lines = nil, 0
+		}
+		batch = append(batch, line...)
+		lines++
+	}
+	if lines == 0 {
+		return nil
+	}
+	return w.send(ctx, batch)
+}
+
+// Report writes s's statistics every interval until the InfluxWriter is
+// closed. Errors are logged.
+func (w *InfluxWriter) Report(s *MonitorStore, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case now := <-ticker.C:
+				if err := w.Write(s, now); err != nil {
+					logger.Errorf("influxdb write error: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the InfluxWriter. Closing it again does nothing.
+func (w *InfluxWriter) Close() error {
+	w.closing.Do(func() { close(w.done) })
+	return nil
+}
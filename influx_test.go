@@ -0,0 +1,141 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWriteInflux(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("hits")
+	mon.WithTags(map[string]string{"host": "a b"}).Val("size", 2)
+	mon.WithTags(map[string]string{"monitor": "x"}).EventNamed("hits")
+
+	var buf bytes.Buffer
+	if err := store.WriteInflux(&buf, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "foo,monitor=hits count=1 1000000000\n" +
+		"foo,monitor=hits,tag_monitor=x count=1 1000000000\n" +
+		`foo,host=a_b,monitor=size avg=2,count=1,max=2,min=2,recent=2,` +
+		"sum=2,sum_squared=4 1000000000\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+func TestInfluxHTTPWriter(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Query().Get("db") != "metrics" {
+				http.Error(w, "database not found", http.StatusNotFound)
+				return
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			bodies = append(bodies, string(body))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	defer server.Close()
+
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("a")
+	mon.EventNamed("b")
+	mon.EventNamed("c")
+
+	writer := NewInfluxHTTPWriter(server.URL+"/write?db=metrics", nil)
+	defer writer.Close()
+	writer.max_lines = 2
+	if err := writer.Write(store, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 || strings.Count(bodies[0], "\n") != 2 ||
+		!strings.HasPrefix(bodies[1], "foo,monitor=c count=1 ") {
+		t.Errorf("unexpected batches: %q", bodies)
+	}
+
+	writer = NewInfluxHTTPWriter(server.URL+"/write?db=other", nil)
+	defer writer.Close()
+	err := writer.Write(store, time.Unix(1, 0))
+	if err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("expected write error, got %v", err)
+	}
+}
+
+func TestInfluxHTTPWriterContext(t *testing.T) {
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			<-stalled
+		}))
+	defer server.Close()
+	defer close(stalled)
+
+	store := NewMonitorStore()
+	store.GetMonitorsNamed("foo").EventNamed("a")
+	writer := NewInfluxHTTPWriter(server.URL+"/write?db=metrics", nil)
+	defer writer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		20*time.Millisecond)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() { errs <- writer.Send(ctx, &Report{*store.Snapshot()}) }()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("expected a timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Send ignored its context")
+	}
+}
+
+func TestInfluxUDPWriter(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+
+	store := NewMonitorStore()
+	store.GetMonitorsNamed("foo").EventNamed("hits")
+	writer, err := NewInfluxUDPWriter(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err := writer.Write(store, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if packet := readPacket(t, conn); packet !=
+		"foo,monitor=hits count=1 1000000000\n" {
+		t.Errorf("unexpected packet %q", packet)
+	}
+
+	// closing twice is harmless
+	writer.Close()
+	writer.Close()
+}
@@ -0,0 +1,284 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	jsonContentType = "application/json"
+)
+
+// jsonFloat is a float64 that marshals NaN and the infinities, which JSON
+// can't represent, as null.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	val := float64(f)
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, val, 'g', -1, 64), nil
+}
+
+// UnmarshalJSON conforms to the json.Unmarshaler interface. null becomes
+// NaN.
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*f = jsonFloat(math.NaN())
+		return nil
+	}
+	val, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	*f = jsonFloat(val)
+	return nil
+}
+
+// jsonStats is the JSON stats view, nested by group, then monitor, then
+// statistic.
+type jsonStats map[string]map[string]map[string]jsonFloat
+
+func newJSONStats(points []Stat) jsonStats {
+	out := make(jsonStats)
+	for _, stat := range points {
+		monitors, ok := out[stat.Group]
+		if !ok {
+			monitors = make(map[string]map[string]jsonFloat)
+			out[stat.Group] = monitors
+		}
+		monitor_name := taggedName(stat.Monitor, stat.Tags)
+		stats, ok := monitors[monitor_name]
+		if !ok {
+			stats = make(map[string]jsonFloat)
+			monitors[monitor_name] = stats
+		}
+		stats[stat.Stat] = jsonFloat(stat.Val)
+	}
+	return out
+}
+
+func (v jsonStats) points() (points []Stat) {
+	for _, group := range sortedKeys(v) {
+		monitors := v[group]
+		for _, monitor_name := range sortedKeys(monitors) {
+			stats := monitors[monitor_name]
+			name, tags := splitTaggedName(monitor_name)
+			for _, subname := range sortedKeys(stats) {
+				points = append(points, Stat{
+					Group:   group,
+					Monitor: name,
+					Stat:    subname,
+					Tags:    tags,
+					Val:     float64(stats[subname])})
+			}
+		}
+	}
+	return points
+}
+
+// jsonTask is a running task in the JSON running view.
+type jsonTask struct {
+	Elapsed        string  `json:"elapsed"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// jsonRunning is the JSON running view, nested by group, then task monitor,
+// with a list of running tasks for each task monitor.
+type jsonRunning map[string]map[string][]jsonTask
+
+func newJSONRunning(running []RunningTask) jsonRunning {
+	out := make(jsonRunning)
+	for _, task := range running {
+		monitors, ok := out[task.Group]
+		if !ok {
+			monitors = make(map[string][]jsonTask)
+			out[task.Group] = monitors
+		}
+		monitor_name := taggedName(task.Monitor, task.Tags)
+		monitors[monitor_name] = append(monitors[monitor_name], jsonTask{
+			Elapsed:        task.Elapsed.String(),
+			ElapsedSeconds: task.Elapsed.Seconds()})
+	}
+	return out
+}
+
+func (v jsonRunning) tasks() (running []RunningTask) {
+	for _, group := range sortedKeys(v) {
+		monitors := v[group]
+		for _, monitor_name := range sortedKeys(monitors) {
+			name, tags := splitTaggedName(monitor_name)
+			for _, task := range monitors[monitor_name] {
+				running = append(running, RunningTask{
+					Group:   group,
+					Monitor: name,
+					Tags:    tags,
+					Elapsed: time.Duration(
+						task.ElapsedSeconds * float64(time.Second))})
+			}
+		}
+	}
+	sort.Sort(runningTasks(running))
+	return running
+}
+
+// jsonDataset is a dataset in the JSON datapoints view.
+type jsonDataset struct {
+	Total    uint64        `json:"total"`
+	Clipped  bool          `json:"clipped"`
+	Fraction float64       `json:"fraction"`
+	Data     [][]jsonFloat `json:"data"`
+}
+
+// jsonDatapoints is the JSON datapoints view, nested by group, then
+// collector, then dataset.
+type jsonDatapoints map[string]map[string]map[string]jsonDataset
+
+func newJSONDatapoints(datasets []Dataset) jsonDatapoints {
+	out := make(jsonDatapoints)
+	for _, dataset := range datasets {
+		collectors, ok := out[dataset.Group]
+		if !ok {
+			collectors = make(map[string]map[string]jsonDataset)
+			out[dataset.Group] = collectors
+		}
+		collector_name := taggedName(dataset.Collector, dataset.Tags)
+		named, ok := collectors[collector_name]
+		if !ok {
+			named = make(map[string]jsonDataset)
+			collectors[collector_name] = named
+		}
+		rows := make([][]jsonFloat, 0, len(dataset.Data))
+		for _, points := range dataset.Data {
+			row := make([]jsonFloat, 0, len(points))
+			for _, point := range points {
+				row = append(row, jsonFloat(point))
+			}
+			rows = append(rows, row)
+		}
+		named[dataset.Name] = jsonDataset{
+			Total:    dataset.Total,
+			Clipped:  dataset.Clipped,
+			Fraction: dataset.Fraction,
+			Data:     rows}
+	}
+	return out
+}
+
+func (v jsonDatapoints) datasets() (datasets []Dataset) {
+	for _, group := range sortedKeys(v) {
+		collectors := v[group]
+		for _, collector_name := range sortedKeys(collectors) {
+			named := collectors[collector_name]
+			name, tags := splitTaggedName(collector_name)
+			for _, subname := range sortedKeys(named) {
+				dataset := named[subname]
+				data := make([][]float64, 0, len(dataset.Data))
+				for _, row := range dataset.Data {
+					points := make([]float64, 0, len(row))
+					for _, point := range row {
+						points = append(points, float64(point))
+					}
+					data = append(data, points)
+				}
+				datasets = append(datasets, Dataset{
+					Group:     group,
+					Collector: name,
+					Name:      subname,
+					Tags:      tags,
+					Data:      data,
+					Total:     dataset.Total,
+					Clipped:   dataset.Clipped,
+					Fraction:  dataset.Fraction})
+			}
+		}
+	}
+	return datasets
+}
+
+// sortedKeys returns the keys of m, which must be a map with string keys, in
+// sorted order.
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	rv := make([]string, 0, len(keys))
+	for _, key := range keys {
+		rv = append(rv, key.String())
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// WriteJSON writes all of the MonitorStore's statistics to w as a JSON object
+// nested by group, then monitor, then statistic, like
+//
+//	{"group": {"monitor": {"count": 3, "max": 1.5}}}
+//
+// Values that JSON can't represent, like NaN, are written as null.
+func (s *MonitorStore) WriteJSON(w io.Writer) error {
+	return writeJSON(w, newJSONStats(s.collectStats()))
+}
+
+// WriteRunningJSON writes the MonitorStore's running tasks to w as a JSON
+// object nested by group, then task monitor. Each task monitor has a list of
+// its running tasks with the longest running first.
+func (s *MonitorStore) WriteRunningJSON(w io.Writer) error {
+	return writeJSON(w, newJSONRunning(s.collectRunning()))
+}
+
+// WriteDatapointsJSON writes the MonitorStore's datasets to w as a JSON
+// object nested by group, then collector, then dataset. Each dataset has its
+// total, clipped and fraction values next to its data. The datasets are not
+// reset.
+func (s *MonitorStore) WriteDatapointsJSON(w io.Writer) error {
+	return writeJSON(w, newJSONDatapoints(s.collectDatasets(false)))
+}
+
+// eachGroup calls cb with each of the MonitorStore's groups in name order.
+func (s *MonitorStore) eachGroup(cb func(group *MonitorGroup)) {
+	snapshot := s.groups.Snapshot()
+	for _, name := range sortedStringKeys(snapshot) {
+		if group, ok := snapshot[name].(*MonitorGroup); ok {
+			cb(group)
+		}
+	}
+}
+
+func writeJSON(w io.Writer, out interface{}) error {
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// jsonFormat returns whether req asked for JSON, with either a format=json
+// query parameter or an Accept header.
+func jsonFormat(req *http.Request) bool {
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return strings.Contains(req.Header.Get("Accept"), jsonContentType)
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveJSON(t *testing.T, store *MonitorStore, url string,
+	out interface{}) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != jsonContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("bad json %q: %s", rec.Body.String(), err)
+	}
+}
+
+func TestJSONStats(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("hits")
+	mon.Chain("odd", MonitorFunc(func(cb func(string, float64)) {
+		cb("nan", math.NaN())
+	}))
+
+	var stats map[string]map[string]map[string]*float64
+	serveJSON(t, store, "/?format=json", &stats)
+	if hits := stats["foo"]["hits"]["count"]; hits == nil || *hits != 1 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+	if nan, ok := stats["foo"]["odd"]["nan"]; !ok || nan != nil {
+		t.Errorf("NaN not written as null: %v", stats)
+	}
+}
+
+func TestJSONRunningAndDatapoints(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	finish := mon.TaskNamed("slow")
+	defer finish(nil)
+	defer func(fraction float64) {
+		Config.DefaultCollectionFraction = fraction
+	}(Config.DefaultCollectionFraction)
+	Config.DefaultCollectionFraction = 1
+	mon.Data("sizes", 1, 2)
+
+	var running map[string]map[string][]jsonTask
+	serveJSON(t, store, "/running?format=json", &running)
+	if len(running["foo"]["slow"]) != 1 {
+		t.Errorf("unexpected running tasks: %v", running)
+	}
+
+	var datapoints map[string]map[string]map[string]struct {
+		Total uint64      `json:"total"`
+		Data  [][]float64 `json:"data"`
+	}
+	serveJSON(t, store, "/datapoints?format=json", &datapoints)
+	dataset := datapoints["foo"]["sizes"]["data"]
+	if dataset.Total != 1 || len(dataset.Data) != 1 ||
+		len(dataset.Data[0]) != 2 || dataset.Data[0][1] != 2 {
+		t.Errorf("unexpected datapoints: %v", datapoints)
+	}
+}
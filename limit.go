@@ -0,0 +1,168 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/spacemonkeygo/monotime"
+	"golang.org/x/net/context"
+)
+
+// LimitExceeded is the class of error TryStart returns when a TaskMonitor
+// already has as many tasks running as its limit allows.
+var LimitExceeded = Error.NewClass("limit exceeded")
+
+// SetLimit limits how many tasks started with StartLimited or TryStart can
+// run at once. Tasks started any other way don't count towards the limit and
+// are never held back. A limit of zero or less means no limit. Once a
+// TaskMonitor has had a limit set, it also reports its limit, how many tasks
+// are queued waiting to start, the queued_highwater, how many tasks gave up
+// waiting as queue_timeout, how many tasks TryStart rejected, and how long
+// tasks waited to start as queue_time_*, in seconds.
+func (t *TaskMonitor) SetLimit(limit int) {
+	t.mtx.Lock()
+	t.limited = true
+	t.limit = limit
+	t.grantSlots()
+	t.mtx.Unlock()
+}
+
+// Limit returns the TaskMonitor's limit, or zero if it has none.
+func (t *TaskMonitor) Limit() int {
+	t.mtx.Lock()
+	limit := t.limit
+	t.mtx.Unlock()
+	return limit
+}
+
+// StartLimited works like StartContext, but first waits until fewer tasks
+// are running than the TaskMonitor's limit. Waiting tasks start in the order
+// they started waiting. If ctx is done before the task can start, the task
+// is counted as a queue_timeout and ctx's error is returned. ctx may be nil,
+// in which case StartLimited waits as long as it takes. The time spent
+// waiting isn't part of the task's duration.
+func (t *TaskMonitor) StartLimited(ctx context.Context) (func(*error), error) {
+	return t.startLimited(ctx, true, func() *TaskCtx {
+		return t.NewContextFor(ctx)
+	})
+}
+
+// TryStart works like Start, unless the TaskMonitor already has as many
+// tasks running or waiting as its limit allows, in which case the task is
+// counted as rejected and a LimitExceeded error is returned.
+func (t *TaskMonitor) TryStart() (func(*error), error) {
+	return t.startLimited(nil, false, t.NewContext)
+}
+
+// startLimited takes a slot, waiting for one if wait is set, and starts the
+// task with begin. The returned function gives the slot back once the task
+// finishes.
+func (t *TaskMonitor) startLimited(ctx context.Context, wait bool,
+	begin func() *TaskCtx) (func(*error), error) {
+	err := t.acquire(ctx, wait)
+	if err != nil {
+		return nil, err
+	}
+	c := begin()
+	return func(e *error) {
+		defer t.release()
+		c.Finish(e, recover())
+	}, nil
+}
+
+// acquire takes one of the TaskMonitor's slots. Tasks that have to wait get
+// in line behind the ones already waiting, and are handed a slot by
+// grantSlots when it's their turn.
+func (t *TaskMonitor) acquire(ctx context.Context, wait bool) error {
+	start := monotime.Monotonic()
+	t.mtx.Lock()
+	if t.hasFreeSlot() && (t.waiters == nil || t.waiters.Len() == 0) {
+		t.holding += 1
+		t.mtx.Unlock()
+		if wait {
+			t.queue_time.Add(0)
+		}
+		return nil
+	}
+	if !wait {
+		t.rejected += 1
+		limit := t.limit
+		t.mtx.Unlock()
+		return LimitExceeded.New("%d tasks already running", limit)
+	}
+	if t.waiters == nil {
+		t.waiters = list.New()
+	}
+	granted := make(chan struct{})
+	waiter := t.waiters.PushBack(granted)
+	t.queued += 1
+	if t.queued > t.queued_highwater {
+		t.queued_highwater = t.queued
+	}
+	t.mtx.Unlock()
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-granted:
+	case <-done:
+		t.mtx.Lock()
+		select {
+		case <-granted:
+			// the slot was handed over just as ctx finished, so pass it on
+			t.holding -= 1
+			t.grantSlots()
+		default:
+			t.waiters.Remove(waiter)
+			t.queued -= 1
+		}
+		t.queue_timeout += 1
+		t.mtx.Unlock()
+		return ctx.Err()
+	}
+	t.queue_time.Add(
+		float64(monotime.Monotonic()-start) / float64(time.Second))
+	return nil
+}
+
+// release gives back a slot taken with acquire.
+func (t *TaskMonitor) release() {
+	t.mtx.Lock()
+	t.holding -= 1
+	t.grantSlots()
+	t.mtx.Unlock()
+}
+
+// hasFreeSlot returns whether another task fits under the limit. t.mtx must
+// be held.
+func (t *TaskMonitor) hasFreeSlot() bool {
+	return t.limit <= 0 || t.holding < t.limit
+}
+
+// grantSlots hands free slots to the tasks that have waited longest, one
+// each, until either the slots or the waiting tasks run out. t.mtx must be
+// held.
+func (t *TaskMonitor) grantSlots() {
+	for t.waiters != nil && t.waiters.Len() > 0 && t.hasFreeSlot() {
+		granted := t.waiters.Remove(t.waiters.Front()).(chan struct{})
+		t.queued -= 1
+		t.holding += 1
+		close(granted)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTaskLimit(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	mon.SetTaskLimit("bar", 1)
+
+	first, err := mon.TryTaskNamed("bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := mon.TryTaskNamed("bar"); !LimitExceeded.Contains(err) {
+		t.Fatalf("expected LimitExceeded, got %v", err)
+	}
+
+	// a queued task gives up when its context is done
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, err = mon.LimitedTaskNamed(ctx, "bar")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// a queued task starts once the running one finishes
+	started := make(chan struct{})
+	go func() {
+		done, err := mon.LimitedTaskNamed(nil, "bar")
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		close(started)
+		done(nil)
+	}()
+	for Collect(mon)["foo.bar.queued"] != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-started:
+		t.Fatalf("task started over the limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+	first(nil)
+	<-started
+
+	stats := Collect(mon)
+	for name, expected := range map[string]float64{
+		"foo.bar.limit":            1,
+		"foo.bar.queued":           0,
+		"foo.bar.queued_highwater": 1,
+		"foo.bar.rejected":         1,
+		"foo.bar.queue_timeout":    1,
+		"foo.bar.queue_time_count": 1,
+		"foo.bar.total_started":    2,
+	} {
+		if stats[name] != expected {
+			t.Errorf("%s: %f != %f", name, stats[name], expected)
+		}
+	}
+	if wait := stats["foo.bar.queue_time_max"]; wait < .01 {
+		t.Errorf("unexpected queue time: %f", wait)
+	}
+
+	// unlimited tasks don't report limit stats
+	mon.TaskNamed("baz")(nil)
+	if _, ok := Collect(mon)["foo.baz.rejected"]; ok {
+		t.Errorf("unlimited tasks shouldn't report rejected")
+	}
+}
+
+func TestTaskLimitOrder(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	mon.SetTaskLimit("bar", 1)
+	first, err := mon.TryTaskNamed("bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// waiting tasks start one at a time, in the order they queued up
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			done, err := mon.LimitedTaskNamed(nil, "bar")
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			order <- i
+			done(nil)
+		}(i)
+		for Collect(mon)["foo.bar.queued"] != float64(i+1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	first(nil)
+	for i := 0; i < 3; i++ {
+		if started := <-order; started != i {
+			t.Fatalf("task %d started in place of task %d", started, i)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind says how a statistic's value behaves over time.
+type Kind string
+
+const (
+	// Untyped statistics could be anything.
+	Untyped Kind = "untyped"
+	// Counter statistics only ever go up.
+	Counter Kind = "counter"
+	// Gauge statistics can go up and down.
+	Gauge Kind = "gauge"
+)
+
+const (
+	// UnitSeconds is the unit of every duration the built-in monitors
+	// report. TaskMonitor timings are measured in microseconds but reported
+	// in seconds.
+	UnitSeconds = "seconds"
+	// UnitPerSecond is the unit of every rate the built-in monitors report.
+	UnitPerSecond = "per_second"
+)
+
+// StatInfo describes a statistic. Unit is empty for plain numbers.
+type StatInfo struct {
+	Kind Kind
+	Unit string
+	Help string
+}
+
+// DescribedMonitor is a Monitor that can also say what each of its
+// statistics means. All of the built-in monitors are DescribedMonitors.
+type DescribedMonitor interface {
+	Monitor
+	DescribedStats(cb func(name string, info StatInfo, val float64))
+}
+
+// DescribedStats calls mon's DescribedStats if it's a DescribedMonitor, and
+// otherwise reports mon's stats as Untyped.
+func DescribedStats(mon Monitor,
+	cb func(name string, info StatInfo, val float64)) {
+	if described, ok := mon.(DescribedMonitor); ok {
+		described.DescribedStats(cb)
+		return
+	}
+	mon.Stats(func(name string, val float64) {
+		cb(name, StatInfo{Kind: Untyped}, val)
+	})
+}
+
+// describeWith reports mon's stats, using describe to describe each of them.
+func describeWith(mon Monitor, describe func(name string) StatInfo,
+	cb func(name string, info StatInfo, val float64)) {
+	mon.Stats(func(name string, val float64) {
+		cb(name, describe(name), val)
+	})
+}
+
+// withHelp returns a callback that replaces the help text of every stat with
+// description before passing it to cb, unless description is empty.
+func withHelp(description string,
+	cb func(name string, info StatInfo, val float64)) func(name string,
+	info StatInfo, val float64) {
+	if description == "" {
+		return cb
+	}
+	return func(name string, info StatInfo, val float64) {
+		info.Help = description
+		cb(name, info, val)
+	}
+}
+
+// userDescription returns the description mon was created with, such as
+// through MonitorGroup.ValDescribed, if any.
+func userDescription(mon interface{}) string {
+	switch mon := mon.(type) {
+	case *ValueMonitor:
+		return mon.description
+	case *IntValueMonitor:
+		return mon.description
+	case *WindowedValueMonitor:
+		return mon.description
+	case *WindowedIntValueMonitor:
+		return mon.description
+	case *ChainedMonitor:
+		return mon.description
+	}
+	return ""
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (e *EventMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(e, func(string) StatInfo {
+		return StatInfo{Kind: Counter, Help: "times the event happened"}
+	}, cb)
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (v *ValueMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(v, describeValueStat(false), withHelp(v.description, cb))
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (v *IntValueMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(v, describeValueStat(false), withHelp(v.description, cb))
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (v *WindowedValueMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(v, describeValueStat(true), withHelp(v.description, cb))
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (v *WindowedIntValueMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(v, describeValueStat(true), withHelp(v.description, cb))
+}
+
+func describeValueStat(windowed bool) func(name string) StatInfo {
+	count := StatInfo{Kind: Counter, Help: "number of values added"}
+	if windowed {
+		count = StatInfo{Kind: Gauge, Help: "number of values in the window"}
+	}
+	return func(name string) StatInfo {
+		switch name {
+		case "count":
+			return count
+		case "avg":
+			return StatInfo{Kind: Gauge, Help: "average value"}
+		case "max":
+			return StatInfo{Kind: Gauge, Help: "largest value"}
+		case "min":
+			return StatInfo{Kind: Gauge, Help: "smallest value"}
+		case "recent":
+			return StatInfo{Kind: Gauge, Help: "most recent value"}
+		case "sum":
+			return StatInfo{Kind: Gauge, Help: "sum of values"}
+		case "sum_squared":
+			return StatInfo{Kind: Gauge, Help: "sum of squared values"}
+		}
+		return StatInfo{Kind: Untyped}
+	}
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (m *MeterMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(m, describeMeterStat, cb)
+}
+
+func describeMeterStat(name string) StatInfo {
+	switch name {
+	case "count":
+		return StatInfo{Kind: Counter, Help: "number of marks"}
+	case "mean_rate":
+		return StatInfo{Kind: Gauge, Unit: UnitPerSecond,
+			Help: "mean rate since creation"}
+	}
+	return StatInfo{Kind: Gauge, Unit: UnitPerSecond,
+		Help: "exponentially weighted moving average rate"}
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (q *QuantileMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(q, func(name string) StatInfo {
+		switch name {
+		case "count":
+			return StatInfo{Kind: Counter, Help: "number of values added"}
+		case "max", "min", "sum":
+			return describeValueStat(false)(name)
+		}
+		return StatInfo{Kind: Gauge, Help: "estimated percentile"}
+	}, cb)
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (h *Histogram) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(h, describeHistogramStat(false), cb)
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (h *WindowedHistogram) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(h, describeHistogramStat(true), cb)
+}
+
+func describeHistogramStat(windowed bool) func(name string) StatInfo {
+	count := StatInfo{Kind: Counter, Help: "number of values added"}
+	bucket := StatInfo{Kind: Counter,
+		Help: "number of values less than or equal to the bound"}
+	if windowed {
+		count = StatInfo{Kind: Gauge, Help: "number of values in the window"}
+		bucket = StatInfo{Kind: Gauge,
+			Help: "number of values in the window up to the bound"}
+	}
+	return func(name string) StatInfo {
+		switch {
+		case name == "count":
+			return count
+		case strings.HasPrefix(name, "bucket_le_"):
+			return bucket
+		}
+		return StatInfo{Kind: Gauge, Help: "estimated percentile"}
+	}
+}
+
+// DescribedStats conforms to the DescribedMonitor interface. The bucket
+// counts of windowed task monitors are gauges.
+func (t *TaskMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	_, windowed := t.total_hist.(*WindowedHistogram)
+	describeWith(t, func(name string) StatInfo {
+		info := describeTaskStat(name)
+		if windowed && strings.HasPrefix(name, "time_") &&
+			strings.Contains(name, "_bucket_le_") {
+			info.Kind = Gauge
+		}
+		return info
+	}, cb)
+}
+
+func describeTaskStat(name string) StatInfo {
+	switch name {
+	case "canceled":
+		return StatInfo{Kind: Counter,
+			Help: "tasks that ended because their context was canceled"}
+	case "current":
+		return StatInfo{Kind: Gauge, Help: "tasks currently running"}
+	case "deadline_exceeded":
+		return StatInfo{Kind: Counter,
+			Help: "tasks that ended because their context's deadline passed"}
+	case "deadline_outlived":
+		return StatInfo{Kind: Counter,
+			Help: "tasks that finished after their context's deadline"}
+	case "highwater":
+		return StatInfo{Kind: Gauge, Help: "most tasks ever running at once"}
+	case "limit":
+		return StatInfo{Kind: Gauge, Help: "most limited tasks allowed at once"}
+	case "panics":
+		return StatInfo{Kind: Counter, Help: "tasks that panicked"}
+	case "queue_timeout":
+		return StatInfo{Kind: Counter,
+			Help: "tasks whose context ended while they waited to start"}
+	case "queued":
+		return StatInfo{Kind: Gauge, Help: "tasks waiting to start"}
+	case "queued_highwater":
+		return StatInfo{Kind: Gauge,
+			Help: "most tasks ever waiting to start at once"}
+	case "rejected":
+		return StatInfo{Kind: Counter,
+			Help: "tasks turned away because the limit was reached"}
+	case "stuck":
+		return StatInfo{Kind: Counter,
+			Help: "tasks a Watchdog found running past their threshold"}
+	case "success":
+		return StatInfo{Kind: Counter, Help: "tasks that succeeded"}
+	case "total_completed":
+		return StatInfo{Kind: Counter, Help: "tasks that finished"}
+	case "total_started":
+		return StatInfo{Kind: Counter, Help: "tasks that started"}
+	}
+	switch {
+	case strings.HasPrefix(name, "error_"):
+		return StatInfo{Kind: Counter, Help: fmt.Sprintf(
+			"tasks that failed with %s", strings.TrimPrefix(name, "error_"))}
+	case strings.HasPrefix(name, "deadline_remaining_"):
+		stat := strings.TrimPrefix(name, "deadline_remaining_")
+		info := describeValueStat(false)(stat)
+		if info.Kind == Gauge && stat != "sum_squared" {
+			info.Unit = UnitSeconds
+		}
+		info.Help = fmt.Sprintf("time left until the deadline at start: %s",
+			info.Help)
+		return info
+	case strings.HasPrefix(name, "queue_time_"):
+		stat := strings.TrimPrefix(name, "queue_time_")
+		info := describeValueStat(false)(stat)
+		if info.Kind == Gauge && stat != "sum_squared" {
+			info.Unit = UnitSeconds
+		}
+		info.Help = fmt.Sprintf("time spent waiting to start: %s", info.Help)
+		return info
+	case strings.HasPrefix(name, "rate_"):
+		return StatInfo{Kind: Gauge, Unit: UnitPerSecond,
+			Help: "rate of tasks"}
+	case strings.HasPrefix(name, "value_"):
+		name = strings.TrimPrefix(name, "value_")
+		for _, stat := range []string{"sum_squared", "avg", "count", "max",
+			"min", "recent", "sum"} {
+			if !strings.HasSuffix(name, "_"+stat) {
+				continue
+			}
+			info := describeValueStat(false)(stat)
+			info.Help = fmt.Sprintf("recorded %s: %s",
+				strings.TrimSuffix(name, "_"+stat), info.Help)
+			return info
+		}
+	case strings.HasPrefix(name, "time_"):
+		parts := strings.SplitN(name, "_", 3)
+		if len(parts) < 3 {
+			break
+		}
+		stat := parts[2]
+		var info StatInfo
+		switch {
+		case stat == "count":
+			// only windowed timings report their own count
+			info = StatInfo{Kind: Gauge, Help: "number of tasks in the window"}
+		case strings.HasPrefix(stat, "bucket_le_"):
+			info = describeHistogramStat(false)(stat)
+		default:
+			info = describeValueStat(false)(stat)
+			if info.Kind == Untyped {
+				info = describeHistogramStat(false)(stat)
+			}
+			if stat != "sum_squared" {
+				info.Unit = UnitSeconds
+			}
+		}
+		info.Help = fmt.Sprintf("%s duration: %s", parts[1], info.Help)
+		return info
+	}
+	return StatInfo{Kind: Untyped}
+}
+
+// DescribedStats conforms to the DescribedMonitor interface, and passes the
+// call to the chained monitor.
+func (c *ChainedMonitor) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	c.mtx.Lock()
+	other := c.other
+	c.mtx.Unlock()
+	if other != nil {
+		DescribedStats(other, withHelp(c.description, cb))
+	}
+}
+
+// DescribedStats conforms to the DescribedMonitor interface. Monitors created
+// with a description, as with ValDescribed, have it as the Help of all of
+// their stats.
+func (g *MonitorGroup) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	g.eachDescribedStat(func(cache_key, subname string, info StatInfo,
+		val float64) {
+		cb(fmt.Sprintf("%s.%s.%s", g.group_name, cache_key, subname), info,
+			val)
+	})
+}
+
+// eachDescribedStat calls cb with each of the group's described stats, along
+// with the cache key of the monitor it's from.
+func (g *MonitorGroup) eachDescribedStat(cb func(cache_key, subname string,
+	info StatInfo, val float64)) {
+	g.eachMonitor(func(cache_key string, mon Monitor) {
+		DescribedStats(mon, func(subname string, info StatInfo, val float64) {
+			cb(cache_key, subname, info, val)
+		})
+	})
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (s *MonitorStore) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	s.eachGroup(func(group *MonitorGroup) { group.DescribedStats(cb) })
+}
+
+// taggedDescribedMonitor is a Monitor that can describe its stats along with
+// their tags, as TaggedStats reports them.
+type taggedDescribedMonitor interface {
+	taggedDescribedStats(cb func(name string, tags map[string]string,
+		info StatInfo, val float64))
+}
+
+func (g *MonitorGroup) taggedDescribedStats(cb func(name string,
+	tags map[string]string, info StatInfo, val float64)) {
+	g.eachDescribedStat(func(cache_key, subname string, info StatInfo,
+		val float64) {
+		name, tags := splitTaggedName(cache_key)
+		cb(fmt.Sprintf("%s.%s.%s", g.group_name, name, subname), tags, info,
+			val)
+	})
+}
+
+func (s *MonitorStore) taggedDescribedStats(cb func(name string,
+	tags map[string]string, info StatInfo, val float64)) {
+	s.eachGroup(func(group *MonitorGroup) { group.taggedDescribedStats(cb) })
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDescribedStats(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	func() {
+		defer mon.TaskNamed("bar")(nil)
+	}()
+	mon.ValDescribed("size", "bytes per request", 3)
+	mon.Quantiles("latency", 2)
+	mon.Chain("custom", MonitorFunc(func(cb func(string, float64)) {
+		cb("val", 1)
+	}))
+
+	infos := make(map[string]StatInfo)
+	mon.DescribedStats(func(name string, info StatInfo, val float64) {
+		infos[name] = info
+	})
+	for name, expected := range map[string]StatInfo{
+		"foo.bar.current":        {Kind: Gauge, Help: "tasks currently running"},
+		"foo.bar.total_started":  {Kind: Counter, Help: "tasks that started"},
+		"foo.bar.time_total_avg": {Kind: Gauge, Unit: UnitSeconds, Help: "total duration: average value"},
+		"foo.size.count":         {Kind: Counter, Help: "bytes per request"},
+		"foo.latency.p50":        {Kind: Gauge, Help: "estimated percentile"},
+		"foo.custom.val":         {Kind: Untyped},
+	} {
+		if infos[name] != expected {
+			t.Errorf("%s: got %+v, want %+v", name, infos[name], expected)
+		}
+	}
+}
+
+func TestPrometheusDescriptions(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.ValDescribed("size", "bytes per request", 3)
+	mon.Val("size", 4)
+	mon.Mark("reqs")
+
+	var buf bytes.Buffer
+	if err := store.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# HELP foo_size_max bytes per request\n# TYPE foo_size_max gauge\n",
+		"# TYPE foo_reqs_count counter\n",
+		"# HELP foo_reqs_m1_rate foo.reqs.m1_rate: exponentially weighted " +
+			"moving average rate (per second)\n# TYPE foo_reqs_m1_rate gauge\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in output:\n%s", line, out)
+		}
+	}
+}
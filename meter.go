@@ -0,0 +1,135 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monotime"
+)
+
+const (
+	meterTickInterval = 5 * time.Second
+)
+
+var (
+	meterWindows = []time.Duration{time.Minute, 5 * time.Minute,
+		15 * time.Minute}
+	meterRateNames = []string{"m1_rate", "m5_rate", "m15_rate"}
+)
+
+// ewma is an exponentially weighted moving average of a per-second rate,
+// updated once per meterTickInterval.
+type ewma struct {
+	alpha       float64
+	rate        float64
+	initialized bool
+}
+
+func newEWMA(window time.Duration) ewma {
+	return ewma{alpha: 1 - math.Exp(-meterTickInterval.Seconds()/
+		window.Seconds())}
+}
+
+func (e *ewma) tick(count int64) {
+	instant_rate := float64(count) / meterTickInterval.Seconds()
+	if !e.initialized {
+		e.rate = instant_rate
+		e.initialized = true
+		return
+	}
+	e.rate += e.alpha * (instant_rate - e.rate)
+}
+
+// decay applies ticks idle ticks at once.
+func (e *ewma) decay(ticks int64) {
+	e.rate *= math.Pow(1-e.alpha, float64(ticks))
+}
+
+// MeterMonitor keeps track of the rate at which something happens, as 1, 5
+// and 15 minute exponentially weighted moving averages and as a mean rate
+// since the MeterMonitor was made. All rates are per second.
+type MeterMonitor struct {
+	mtx       sync.Mutex
+	count     int64
+	uncounted int64
+	start     time.Duration
+	last_tick time.Duration
+	rates     []ewma
+	now       func() time.Duration
+}
+
+// NewMeterMonitor makes a new MeterMonitor. You probably want to create a new
+// MeterMonitor using MonitorGroup.Mark instead.
+func NewMeterMonitor() *MeterMonitor {
+	return newMeterMonitor(monotime.Monotonic)
+}
+
+func newMeterMonitor(now func() time.Duration) *MeterMonitor {
+	m := &MeterMonitor{now: now}
+	m.start = now()
+	m.last_tick = m.start
+	for _, window := range meterWindows {
+		m.rates = append(m.rates, newEWMA(window))
+	}
+	return m
+}
+
+// Mark indicates that n more events happened
+func (m *MeterMonitor) Mark(n int64) {
+	m.mtx.Lock()
+	m.tickIfNeeded()
+	m.count += n
+	m.uncounted += n
+	m.mtx.Unlock()
+}
+
+// tickIfNeeded brings the moving averages up to date. Ticks are applied
+// lazily, so an idle MeterMonitor costs nothing.
+func (m *MeterMonitor) tickIfNeeded() {
+	ticks := int64((m.now() - m.last_tick) / meterTickInterval)
+	if ticks <= 0 {
+		return
+	}
+	m.last_tick += time.Duration(ticks) * meterTickInterval
+	for i := range m.rates {
+		m.rates[i].tick(m.uncounted)
+		m.rates[i].decay(ticks - 1)
+	}
+	m.uncounted = 0
+}
+
+// Stats conforms to the Monitor interface
+func (m *MeterMonitor) Stats(cb func(name string, val float64)) {
+	m.mtx.Lock()
+	m.tickIfNeeded()
+	count := m.count
+	elapsed := m.now() - m.start
+	rates := make([]float64, 0, len(m.rates))
+	for _, rate := range m.rates {
+		rates = append(rates, rate.rate)
+	}
+	m.mtx.Unlock()
+
+	cb("count", float64(count))
+	for i, rate := range rates {
+		cb(meterRateNames[i], rate)
+	}
+	if elapsed > 0 {
+		cb("mean_rate", float64(count)/elapsed.Seconds())
+	}
+}
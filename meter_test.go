@@ -0,0 +1,54 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeterMonitor(t *testing.T) {
+	var now time.Duration
+	m := newMeterMonitor(func() time.Duration { return now })
+
+	// a steady 10 events per second for 15 minutes
+	for i := 0; i < 15*60; i++ {
+		m.Mark(10)
+		now += time.Second
+	}
+	stats := Collect(m)
+	for _, name := range []string{"m1_rate", "m5_rate", "m15_rate",
+		"mean_rate"} {
+		if math.Abs(stats[name]-10) > .01 {
+			t.Errorf("%s: got %f, want 10", name, stats[name])
+		}
+	}
+	if stats["count"] != 9000 {
+		t.Errorf("count: got %f, want 9000", stats["count"])
+	}
+
+	// after going idle, the short windows decay faster than the long ones
+	now += 5 * time.Minute
+	stats = Collect(m)
+	if !(stats["m1_rate"] < stats["m5_rate"] &&
+		stats["m5_rate"] < stats["m15_rate"] && stats["m15_rate"] < 10) {
+		t.Errorf("unexpected decay %v", stats)
+	}
+	// 10 * e^-5
+	if math.Abs(stats["m1_rate"]-.0674) > .001 {
+		t.Errorf("m1_rate: got %f, want .0674", stats["m1_rate"])
+	}
+}
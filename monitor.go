@@ -17,6 +17,7 @@ package monitor
 import (
 	"sort"
 
+	"github.com/spacemonkeygo/errors"
 	"github.com/spacemonkeygo/spacelog"
 	"gopkg.in/spacemonkeygo/monitor.v1/trace"
 )
@@ -29,6 +30,10 @@ var (
 
 	logger = spacelog.GetLogger()
 
+	// Error is the class of errors this package returns, such as when a
+	// serialized sketch can't be decoded.
+	Error = errors.NewClass("monitor")
+
 	CallerName             = trace.CallerName
 	PackageName            = trace.PackageName
 	AddIgnoredCallerPrefix = trace.AddIgnoredCallerPrefix
@@ -41,6 +46,13 @@ type Monitor interface {
 	Stats(cb func(name string, val float64))
 }
 
+// TaggedMonitor is implemented by monitors that can report tags alongside
+// their stats. Untagged stats are reported with nil tags. Callbacks must not
+// modify the tags they are given.
+type TaggedMonitor interface {
+	TaggedStats(cb func(name string, tags map[string]string, val float64))
+}
+
 // RunningTasksCollector keeps track of tasks that are currently in process.
 type RunningTasksCollector interface {
 	Running(cb func(name string, current []*TaskCtx))
@@ -77,9 +89,24 @@ func sortedStringKeys(snapshot map[interface{}]interface{}) []string {
 	return keys
 }
 
+func sortedFloatKeys(data map[string]float64) []string {
+	keys := make([]string, 0, len(data))
+	for name := range data {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Stats calls cb with all the statistics registered on the default store.
 func Stats(cb func(name string, val float64)) { DefaultStore.Stats(cb) }
 
+// TaggedStats calls cb with all the statistics registered on the default
+// store, with any tags reported separately from the name.
+func TaggedStats(cb func(name string, tags map[string]string, val float64)) {
+	DefaultStore.TaggedStats(cb)
+}
+
 // Running calls cb with lists of currently running tasks by name.
 func Running(cb func(name string, current []*TaskCtx)) {
 	DefaultStore.Running(cb)
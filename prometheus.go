@@ -0,0 +1,396 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; " +
+		"charset=utf-8"
+)
+
+// PrometheusName converts a dotted stat name, such as the ones passed to
+// the MonitorStore.Stats callback, into a valid Prometheus metric name.
+func PrometheusName(name string) string {
+	rname := []byte(name)
+	for i, r := range rname {
+		switch {
+		case r >= 'A' && r <= 'Z':
+		case r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				return PrometheusName("_" + name)
+			}
+		case r == '_' || r == ':':
+		default:
+			rname[i] = '_'
+		}
+	}
+	return string(rname)
+}
+
+type promSample struct {
+	suffix string
+	labels string
+	val    float64
+}
+
+// promFamily is a Prometheus metric family: a set of samples sharing a name,
+// a type and a help string.
+type promFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []promSample
+}
+
+func (f *promFamily) add(suffix, labels string, val float64) {
+	f.samples = append(f.samples, promSample{
+		suffix: suffix, labels: labels, val: val})
+}
+
+// WritePrometheus writes all of the MonitorStore's statistics to w in the
+// Prometheus text exposition format.
+func (s *MonitorStore) WritePrometheus(w io.Writer) error {
+	return s.writePrometheus(w, false)
+}
+
+// WriteOpenMetrics writes all of the MonitorStore's statistics to w in the
+// OpenMetrics text exposition format.
+func (s *MonitorStore) WriteOpenMetrics(w io.Writer) error {
+	return s.writePrometheus(w, true)
+}
+
+func (s *MonitorStore) writePrometheus(w io.Writer, openmetrics bool) error {
+	var names []string
+	families := make(map[string]*promFamily)
+	s.prometheusFamilies(func(f *promFamily) {
+		existing, ok := families[f.name]
+		if !ok {
+			families[f.name] = f
+			names = append(names, f.name)
+			return
+		}
+		// tagged monitors share a family with the other monitors by the same
+		// name. if the types disagree, two distinct dotted names mapped to
+		// the same metric name, and since scrapers reject the whole
+		// exposition if a family repeats, the later one is dropped.
+		if existing.kind == f.kind {
+			existing.samples = append(existing.samples, f.samples...)
+		}
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		writePromFamily(bw, families[name], openmetrics)
+	}
+	if openmetrics {
+		fmt.Fprint(bw, "# EOF\n")
+	}
+	return bw.Flush()
+}
+
+func (s *MonitorStore) prometheusFamilies(cb func(f *promFamily)) {
+	snapshot := s.groups.Snapshot()
+	for _, name := range sortedStringKeys(snapshot) {
+		group, ok := snapshot[name].(*MonitorGroup)
+		if !ok {
+			continue
+		}
+		group.prometheusFamilies(cb)
+	}
+}
+
+func (g *MonitorGroup) prometheusFamilies(cb func(f *promFamily)) {
+	snapshot := g.monitors.Snapshot()
+	for _, cache_key := range sortedStringKeys(snapshot) {
+		name, tags := splitTaggedName(cache_key)
+		full_name := fmt.Sprintf("%s.%s", g.group_name, name)
+		family_cb := cb
+		if len(tags) > 0 {
+			family_cb = promTagger(tags, family_cb)
+		}
+		description := userDescription(snapshot[cache_key])
+		if description != "" {
+			family_cb = promDescriber(description, family_cb)
+		}
+		switch mon := snapshot[cache_key].(type) {
+		case *TaskMonitor:
+			taskPromFamilies(full_name, mon, family_cb)
+		case *QuantileMonitor:
+			stats := Collect(mon)
+			family_cb(summaryPromFamily(PrometheusName(full_name), full_name,
+				stats, "", stats["sum"], stats["count"]))
+		case Monitor:
+			monitorPromFamilies(full_name, mon, family_cb)
+		}
+	}
+}
+
+// promDescriber returns a callback that replaces the help text of every
+// family with description before passing it on to cb.
+func promDescriber(description string,
+	cb func(f *promFamily)) func(f *promFamily) {
+	return func(f *promFamily) {
+		f.help = description
+		cb(f)
+	}
+}
+
+// promTagger returns a callback that adds tags as labels to every sample of a
+// family before passing it on to cb.
+func promTagger(tags map[string]string,
+	cb func(f *promFamily)) func(f *promFamily) {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	labels := make([]string, 0, len(keys))
+	for _, key := range keys {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`,
+			PrometheusName(key), promEscape(tags[key], true)))
+	}
+	tag_labels := strings.Join(labels, ",")
+	return func(f *promFamily) {
+		for i := range f.samples {
+			if f.samples[i].labels == "" {
+				f.samples[i].labels = tag_labels
+			} else {
+				f.samples[i].labels = tag_labels + "," + f.samples[i].labels
+			}
+		}
+		cb(f)
+	}
+}
+
+// monitorPromFamilies turns every stat of mon into its own single-sample
+// family, typed by the stat's Kind. Monitors that aren't DescribedMonitors
+// are untyped.
+func monitorPromFamilies(name string, mon Monitor, cb func(f *promFamily)) {
+	DescribedStats(mon, func(subname string, info StatInfo, val float64) {
+		full_name := fmt.Sprintf("%s.%s", name, subname)
+		f := &promFamily{
+			name: PrometheusName(full_name),
+			help: promHelp(full_name, info),
+			kind: string(info.Kind)}
+		f.add("", "", val)
+		cb(f)
+	})
+}
+
+// promHelp returns the help text for the stat called name.
+func promHelp(name string, info StatInfo) string {
+	help := name
+	if info.Help != "" {
+		help = fmt.Sprintf("%s: %s", name, info.Help)
+	}
+	if info.Unit != "" {
+		help = fmt.Sprintf("%s (%s)", help,
+			strings.Replace(info.Unit, "_", " ", -1))
+	}
+	return help
+}
+
+// taskPromFamilies exports a TaskMonitor with counters for its totals, gauges
+// for its concurrency, a labeled counter for its error classes and summaries
+// for its timings and recorded values. The summaries report the minimum and
+// maximum as the 0 and 1 quantiles, along with whatever quantiles the timing
+// histograms estimate.
+func taskPromFamilies(name string, mon *TaskMonitor, cb func(f *promFamily)) {
+	stats := Collect(mon)
+	base := PrometheusName(name)
+
+	single := func(subname, kind string) {
+		val, ok := stats[subname]
+		if !ok {
+			return
+		}
+		f := &promFamily{
+			name: fmt.Sprintf("%s_%s", base, subname),
+			help: promHelp(fmt.Sprintf("%s.%s", name, subname),
+				describeTaskStat(subname)),
+			kind: kind}
+		f.add("", "", val)
+		cb(f)
+	}
+
+	single("canceled", "counter")
+	single("current", "gauge")
+	single("deadline_exceeded", "counter")
+	single("deadline_outlived", "counter")
+	if sum, ok := stats["deadline_remaining_sum"]; ok {
+		cb(summaryPromFamily(base+"_deadline_remaining_seconds",
+			fmt.Sprintf("%s.deadline_remaining_*", name), stats,
+			"deadline_remaining_", sum, stats["deadline_remaining_count"]))
+	}
+
+	errors := &promFamily{
+		name: base + "_errors",
+		help: fmt.Sprintf("%s.error_*", name),
+		kind: "counter"}
+	var error_count float64
+	for _, subname := range sortedFloatKeys(stats) {
+		if !strings.HasPrefix(subname, "error_") {
+			continue
+		}
+		error_count += stats[subname]
+		errors.add("", fmt.Sprintf(`class="%s"`,
+			promEscape(strings.TrimPrefix(subname, "error_"), true)),
+			stats[subname])
+	}
+	if len(errors.samples) > 0 {
+		cb(errors)
+	}
+
+	single("highwater", "gauge")
+	single("limit", "gauge")
+	single("panics", "counter")
+	if sum, ok := stats["queue_time_sum"]; ok {
+		cb(summaryPromFamily(base+"_queue_time_seconds",
+			fmt.Sprintf("%s.queue_time_*", name), stats,
+			"queue_time_", sum, stats["queue_time_count"]))
+	}
+	single("queue_timeout", "counter")
+	single("queued", "gauge")
+	single("queued_highwater", "gauge")
+	for _, kind := range []string{"started", "completed", "error"} {
+		for _, rate := range append(meterRateNames, "mean_rate") {
+			single(fmt.Sprintf("rate_%s_%s", kind, rate), "gauge")
+		}
+	}
+	single("rejected", "counter")
+	single("stuck", "counter")
+	single("success", "counter")
+
+	for _, timing := range []struct {
+		kind  string
+		count float64
+	}{
+		{"error", error_count},
+		{"success", stats["success"]},
+		{"total", stats["total_completed"]},
+	} {
+		prefix := fmt.Sprintf("time_%s_", timing.kind)
+		sum, ok := stats[prefix+"sum"]
+		if !ok {
+			continue
+		}
+		cb(summaryPromFamily(
+			fmt.Sprintf("%s_time_%s_seconds", base, timing.kind),
+			fmt.Sprintf("%s.%s*", name, prefix),
+			stats, prefix, sum, timing.count))
+	}
+
+	single("total_completed", "counter")
+	single("total_started", "counter")
+
+	// recorded values, including their success and error splits
+	for _, subname := range sortedFloatKeys(stats) {
+		if !strings.HasPrefix(subname, "value_") ||
+			!strings.HasSuffix(subname, "_count") {
+			continue
+		}
+		prefix := strings.TrimSuffix(subname, "count")
+		cb(summaryPromFamily(
+			PrometheusName(fmt.Sprintf("%s_%s", base,
+				strings.TrimSuffix(prefix, "_"))),
+			fmt.Sprintf("%s.%s*", name, prefix),
+			stats, prefix, stats[prefix+"sum"], stats[subname]))
+	}
+}
+
+// summaryPromFamily builds a summary out of the min, max and percentile stats
+// whose names start with prefix.
+func summaryPromFamily(name, help string, stats map[string]float64,
+	prefix string, sum, count float64) *promFamily {
+	f := &promFamily{name: name, help: help, kind: "summary"}
+	if count > 0 {
+		if val, ok := stats[prefix+"min"]; ok {
+			f.add("", `quantile="0"`, val)
+		}
+		for _, q := range HistogramQuantiles {
+			if val, ok := stats[prefix+quantileName(q)]; ok {
+				f.add("", fmt.Sprintf(`quantile="%s"`,
+					strconv.FormatFloat(q, 'g', -1, 64)), val)
+			}
+		}
+		if val, ok := stats[prefix+"max"]; ok {
+			f.add("", `quantile="1"`, val)
+		}
+	}
+	f.add("_sum", "", sum)
+	f.add("_count", "", count)
+	return f
+}
+
+func writePromFamily(w io.Writer, f *promFamily, openmetrics bool) {
+	kind := f.kind
+	family_name := f.name
+	counter_suffix := ""
+	if openmetrics {
+		switch kind {
+		case "untyped":
+			kind = "unknown"
+		case "counter":
+			// OpenMetrics counter samples must end in _total, and the family
+			// is named without it.
+			family_name = strings.TrimSuffix(family_name, "_total")
+			counter_suffix = "_total"
+		}
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", family_name, promEscape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", family_name, kind)
+	for _, sample := range f.samples {
+		fmt.Fprint(w, family_name, sample.suffix, counter_suffix)
+		if sample.labels != "" {
+			fmt.Fprintf(w, "{%s}", sample.labels)
+		}
+		fmt.Fprintf(w, " %s\n", strconv.FormatFloat(sample.val, 'g', -1, 64))
+	}
+}
+
+// promEscape escapes HELP text, or label values if quotes is true.
+func promEscape(val string, quotes bool) string {
+	val = strings.Replace(val, `\`, `\\`, -1)
+	val = strings.Replace(val, "\n", `\n`, -1)
+	if quotes {
+		val = strings.Replace(val, `"`, `\"`, -1)
+	}
+	return val
+}
+
+// prometheusFormat determines whether req asks for a Prometheus or
+// OpenMetrics exposition, either through its Accept header or by requesting
+// a path ending in "metrics".
+func prometheusFormat(req *http.Request) (prometheus, openmetrics bool) {
+	accept := req.Header.Get("Accept")
+	if strings.Contains(accept, "application/openmetrics-text") {
+		return true, true
+	}
+	if strings.Contains(accept, "version=0.0.4") {
+		return true, false
+	}
+	return strings.HasSuffix(req.URL.Path, "metrics"), false
+}
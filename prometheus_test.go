@@ -0,0 +1,115 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusName(t *testing.T) {
+	for in, out := range map[string]string{
+		"foo.bar.current":    "foo_bar_current",
+		"9lives.x-y.count":   "_9lives_x_y_count",
+		"env.memory.Mallocs": "env_memory_Mallocs",
+	} {
+		if got := PrometheusName(in); got != out {
+			t.Errorf("PrometheusName(%q) = %q, want %q", in, got, out)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	func() {
+		defer mon.TaskNamed("bar")(nil)
+	}()
+	func() {
+		var err error
+		defer mon.TaskNamed("bar")(&err)
+		err = io.EOF
+	}()
+	mon.EventNamed("hits")
+	mon.Val("size", 3)
+
+	var buf bytes.Buffer
+	if err := store.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE foo_bar_current gauge\nfoo_bar_current 0\n",
+		"# TYPE foo_bar_errors counter\n",
+		"# TYPE foo_bar_success counter\nfoo_bar_success 1\n",
+		"# TYPE foo_bar_time_total_seconds summary\n",
+		"foo_bar_time_total_seconds_count 2\n",
+		"foo_bar_time_error_seconds_count 1\n",
+		"# TYPE foo_bar_total_started counter\nfoo_bar_total_started 2\n",
+		"# HELP foo_hits_count foo.hits.count: times the event happened\n" +
+			"# TYPE foo_hits_count counter\nfoo_hits_count 1\n",
+		"# TYPE foo_size_count counter\n",
+		"# TYPE foo_size_max gauge\nfoo_size_max 3\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in output:\n%s", line, out)
+		}
+	}
+
+	buf.Reset()
+	if err := store.WriteOpenMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	if !strings.Contains(out, "foo_hits_count_total 1\n") {
+		t.Errorf("counter sample without _total suffix:\n%s", out)
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Errorf("missing EOF marker:\n%s", out)
+	}
+}
+
+func TestServeHTTPPrometheus(t *testing.T) {
+	store := NewMonitorStore()
+	store.GetMonitorsNamed("foo").EventNamed("hits")
+
+	for _, tc := range []struct {
+		path, accept, content_type string
+	}{
+		{"/stats", "", "text/plain"},
+		{"/metrics", "", prometheusContentType},
+		{"/stats", "text/plain;version=0.0.4", prometheusContentType},
+		{"/stats", "application/openmetrics-text; version=1.0.0",
+			openMetricsContentType},
+	} {
+		req, err := http.NewRequest("GET", tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", tc.accept)
+		w := httptest.NewRecorder()
+		store.ServeHTTP(w, req)
+		if got := w.HeaderMap.Get("Content-Type"); got != tc.content_type {
+			t.Errorf("%s (%s): content type %q, want %q", tc.path, tc.accept,
+				got, tc.content_type)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultCompression is the t-digest compression used by QuantileMonitors
+	// made with NewQuantileMonitor. Larger values are more accurate but keep
+	// more centroids.
+	DefaultCompression = 100
+
+	quantileEncodingVersion = 1
+)
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+type centroids []centroid
+
+func (c centroids) Len() int           { return len(c) }
+func (c centroids) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c centroids) Less(i, j int) bool { return c[i].mean < c[j].mean }
+
+// QuantileMonitor keeps a t-digest of some value, which is a streaming sketch
+// that estimates quantiles (p50, p99, and so on) in bounded memory. Two
+// QuantileMonitors can be combined with Merge, and a QuantileMonitor can be
+// sent between processes with MarshalBinary and UnmarshalBinary, so sketches
+// from many processes can be aggregated.
+type QuantileMonitor struct {
+	mtx         sync.Mutex
+	compression float64
+	merged      centroids
+	unmerged    centroids
+	count       float64
+	sum         float64
+	max         float64
+	min         float64
+}
+
+// NewQuantileMonitor creates a new QuantileMonitor with DefaultCompression.
+// You probably want to create a new QuantileMonitor through
+// MonitorGroup.Quantiles instead.
+func NewQuantileMonitor() *QuantileMonitor {
+	return NewQuantileMonitorWithCompression(DefaultCompression)
+}
+
+// NewQuantileMonitorWithCompression creates a new QuantileMonitor that keeps
+// roughly compression centroids.
+func NewQuantileMonitorWithCompression(compression float64) *QuantileMonitor {
+	return &QuantileMonitor{
+		compression: compression,
+		max:         math.Inf(-1),
+		min:         math.Inf(1)}
+}
+
+// Add adds a value to the QuantileMonitor
+func (q *QuantileMonitor) Add(val float64) {
+	q.mtx.Lock()
+	q.add(centroid{mean: val, weight: 1})
+	q.mtx.Unlock()
+}
+
+func (q *QuantileMonitor) add(c centroid) {
+	q.unmerged = append(q.unmerged, c)
+	q.count += c.weight
+	q.sum += c.mean * c.weight
+	if c.mean > q.max {
+		q.max = c.mean
+	}
+	if c.mean < q.min {
+		q.min = c.mean
+	}
+	if len(q.unmerged) >= int(5*q.compression) {
+		q.compress()
+	}
+}
+
+// scale is the t-digest k1 scale function, which keeps centroids small near
+// the tails and lets them grow near the median.
+func (q *QuantileMonitor) scale(quantile float64) float64 {
+	return q.compression / (2 * math.Pi) * math.Asin(2*quantile-1)
+}
+
+// compress folds the unmerged centroids into the merged ones.
+func (q *QuantileMonitor) compress() {
+	if len(q.unmerged) == 0 {
+		return
+	}
+	all := append(q.unmerged, q.merged...)
+	sort.Sort(all)
+	out := make(centroids, 0, len(q.merged)+1)
+	current := all[0]
+	var weight_so_far float64
+	limit := q.scale(0) + 1
+	for _, next := range all[1:] {
+		proposed := math.Min(1,
+			(weight_so_far+current.weight+next.weight)/q.count)
+		if q.scale(proposed) <= limit {
+			total := current.weight + next.weight
+			current.mean += (next.mean - current.mean) * next.weight / total
+			current.weight = total
+			continue
+		}
+		weight_so_far += current.weight
+		out = append(out, current)
+		limit = q.scale(weight_so_far/q.count) + 1
+		current = next
+	}
+	q.merged = append(out, current)
+	q.unmerged = q.unmerged[:0]
+}
+
+// Quantile estimates the value below which the fraction quantile of all
+// values fall.
+func (q *QuantileMonitor) Quantile(quantile float64) float64 {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.compress()
+	return q.quantile(quantile)
+}
+
+func (q *QuantileMonitor) quantile(quantile float64) float64 {
+	if len(q.merged) == 0 {
+		return math.NaN()
+	}
+	if len(q.merged) == 1 {
+		return q.merged[0].mean
+	}
+	rank := quantile * q.count
+
+	// interpolate between centroid centers, using min and max to anchor the
+	// tails.
+	first := q.merged[0]
+	if rank < first.weight/2 {
+		return q.min + (first.mean-q.min)*rank/(first.weight/2)
+	}
+	center := first.weight / 2
+	for i := 1; i < len(q.merged); i++ {
+		prev, next := q.merged[i-1], q.merged[i]
+		next_center := center + (prev.weight+next.weight)/2
+		if rank < next_center {
+			return prev.mean + (next.mean-prev.mean)*
+				(rank-center)/(next_center-center)
+		}
+		center = next_center
+	}
+	last := q.merged[len(q.merged)-1]
+	if q.count <= center {
+		return last.mean
+	}
+	return last.mean + (q.max-last.mean)*(rank-center)/(q.count-center)
+}
+
+// Merge adds all of the values other has seen to q. Merging a QuantileMonitor
+// into itself does nothing.
+func (q *QuantileMonitor) Merge(other *QuantileMonitor) {
+	if other == q {
+		return
+	}
+	// other is copied and unlocked before q is locked, so that merges in
+	// both directions at once can't deadlock.
+	other.mtx.Lock()
+	incoming := make(centroids, 0, len(other.merged)+len(other.unmerged))
+	incoming = append(incoming, other.merged...)
+	incoming = append(incoming, other.unmerged...)
+	max, min := other.max, other.min
+	other.mtx.Unlock()
+
+	q.mtx.Lock()
+	for _, c := range incoming {
+		q.add(c)
+	}
+	// centroid means always sit inside [min, max], so the extremes need
+	// carrying over separately.
+	if max > q.max {
+		q.max = max
+	}
+	if min < q.min {
+		q.min = min
+	}
+	q.compress()
+	q.mtx.Unlock()
+}
+
+// quantileHeader precedes the centroid (mean, weight) pairs in an encoded
+// QuantileMonitor.
+type quantileHeader struct {
+	Version     uint8
+	Compression float64
+	Sum         float64
+	Max         float64
+	Min         float64
+	Centroids   uint32
+}
+
+// MarshalBinary encodes the QuantileMonitor's sketch so that it can be sent to
+// another process and merged there.
+func (q *QuantileMonitor) MarshalBinary() ([]byte, error) {
+	q.mtx.Lock()
+	q.compress()
+	header := quantileHeader{
+		Version:     quantileEncodingVersion,
+		Compression: q.compression,
+		Sum:         q.sum,
+		Max:         q.max,
+		Min:         q.min,
+		Centroids:   uint32(len(q.merged))}
+	values := make([]float64, 0, 2*len(q.merged))
+	for _, c := range q.merged {
+		values = append(values, c.mean, c.weight)
+	}
+	q.mtx.Unlock()
+
+	var buf bytes.Buffer
+	err := binary.Write(&buf, binary.BigEndian, header)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&buf, binary.BigEndian, values)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the QuantileMonitor's sketch with one encoded by
+// MarshalBinary. Use Merge to combine it with another QuantileMonitor.
+func (q *QuantileMonitor) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var header quantileHeader
+	err := binary.Read(r, binary.BigEndian, &header)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if header.Version != quantileEncodingVersion {
+		return Error.New(
+			"unknown quantile sketch version %d", header.Version)
+	}
+	if uint64(r.Len()) != 16*uint64(header.Centroids) {
+		return Error.New("truncated quantile sketch")
+	}
+	values := make([]float64, 2*header.Centroids)
+	err = binary.Read(r, binary.BigEndian, values)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	merged := make(centroids, 0, header.Centroids)
+	var count float64
+	for i := 0; i < len(values); i += 2 {
+		merged = append(merged, centroid{mean: values[i], weight: values[i+1]})
+		count += values[i+1]
+	}
+
+	q.mtx.Lock()
+	q.compression = header.Compression
+	q.merged = merged
+	q.unmerged = nil
+	q.count = count
+	q.sum = header.Sum
+	q.max = header.Max
+	q.min = header.Min
+	q.mtx.Unlock()
+	return nil
+}
+
+// Stats conforms to the Monitor interface
+func (q *QuantileMonitor) Stats(cb func(name string, val float64)) {
+	q.mtx.Lock()
+	q.compress()
+	count := q.count
+	sum := q.sum
+	max := q.max
+	min := q.min
+	quantiles := make([]float64, 0, len(HistogramQuantiles))
+	for _, quantile := range HistogramQuantiles {
+		quantiles = append(quantiles, q.quantile(quantile))
+	}
+	q.mtx.Unlock()
+
+	cb("count", count)
+	cb("max", max)
+	cb("min", min)
+	if count > 0 {
+		for i, quantile := range HistogramQuantiles {
+			cb(quantileName(quantile), quantiles[i])
+		}
+	}
+	cb("sum", sum)
+}
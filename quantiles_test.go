@@ -0,0 +1,96 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func checkQuantiles(t *testing.T, q *QuantileMonitor, max float64) {
+	for _, quantile := range []float64{.01, .5, .9, .99, .999} {
+		got := q.Quantile(quantile)
+		if math.Abs(got-quantile*max) > .01*max {
+			t.Errorf("quantile %f: got %f, want about %f", quantile, got,
+				quantile*max)
+		}
+	}
+}
+
+func TestQuantileMonitor(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	q := NewQuantileMonitor()
+	for _, i := range rng.Perm(100000) {
+		q.Add(float64(i))
+	}
+	checkQuantiles(t, q, 100000)
+
+	stats := Collect(q)
+	if stats["count"] != 100000 || stats["min"] != 0 || stats["max"] != 99999 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+	if len(q.merged) > 2*DefaultCompression {
+		t.Errorf("sketch has %d centroids", len(q.merged))
+	}
+}
+
+func TestQuantileMonitorMerge(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	parts := []*QuantileMonitor{NewQuantileMonitor(), NewQuantileMonitor()}
+	for _, i := range rng.Perm(100000) {
+		parts[i%2].Add(float64(i))
+	}
+
+	data, err := parts[1].MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewQuantileMonitor()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := NewQuantileMonitor()
+	merged.Merge(parts[0])
+	merged.Merge(decoded)
+	checkQuantiles(t, merged, 100000)
+
+	stats := Collect(merged)
+	if stats["count"] != 100000 || stats["max"] != 99999 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Errorf("expected an error decoding a truncated sketch")
+	}
+
+	// merging into itself, and in both directions at once, mustn't deadlock
+	merged.Merge(merged)
+	if Collect(merged)["count"] != 100000 {
+		t.Errorf("merging into itself changed the count")
+	}
+	done := make(chan bool)
+	for i := range parts {
+		go func(a, b *QuantileMonitor) {
+			for j := 0; j < 10; j++ {
+				a.Merge(b)
+			}
+			done <- true
+		}(parts[i], parts[1-i])
+	}
+	<-done
+	<-done
+}
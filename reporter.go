@@ -0,0 +1,202 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Report is everything a Reporter collected from its MonitorStore in one
+// interval. Unlike MonitorStore.Snapshot, a Report's datasets have been
+// drained from the store. Sinks share the same Report and must not modify
+// it.
+type Report struct {
+	Snapshot
+}
+
+// Sink receives a Reporter's Reports. Send should give up when ctx is done,
+// which happens when the Sink's timeout passes.
+type Sink interface {
+	Send(ctx context.Context, report *Report) error
+}
+
+// SinkFunc is a Sink made from a function.
+type SinkFunc func(ctx context.Context, report *Report) error
+
+// Send conforms to the Sink interface
+func (f SinkFunc) Send(ctx context.Context, report *Report) error {
+	return f(ctx, report)
+}
+
+type reporterSink struct {
+	name    string
+	sink    Sink
+	timeout time.Duration
+
+	mtx      sync.Mutex
+	sent     uint64
+	errors   uint64
+	timeouts uint64
+}
+
+// Reporter periodically collects a MonitorStore's statistics and datasets and
+// hands them to a list of Sinks. The Reporter drains the store's datasets
+// each interval, so every datapoint is reported once, and nothing else
+// should reset them.
+type Reporter struct {
+	store    *MonitorStore
+	interval time.Duration
+
+	mtx     sync.Mutex
+	sinks   []*reporterSink
+	reports uint64
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewReporter makes a Reporter for store that reports every interval once
+// started.
+func NewReporter(store *MonitorStore, interval time.Duration) *Reporter {
+	return &Reporter{store: store, interval: interval}
+}
+
+// AddSink adds a Sink named name. Each Send call gets timeout to finish
+// before it's counted as timed out and abandoned. A timeout of zero means no
+// timeout.
+func (r *Reporter) AddSink(name string, sink Sink, timeout time.Duration) {
+	r.mtx.Lock()
+	r.sinks = append(r.sinks, &reporterSink{
+		name:    SanitizeName(name),
+		sink:    sink,
+		timeout: timeout})
+	r.mtx.Unlock()
+}
+
+// Start starts reporting every interval in the background.
+func (r *Reporter) Start() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.stopped = make(chan struct{})
+	go r.run(r.stop, r.stopped)
+}
+
+// Stop stops the Reporter, sending one last Report so that nothing collected
+// since the last interval is lost.
+func (r *Reporter) Stop() {
+	r.mtx.Lock()
+	stop, stopped := r.stop, r.stopped
+	r.stop, r.stopped = nil, nil
+	r.mtx.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-stopped
+	r.ReportNow()
+}
+
+func (r *Reporter) run(stop, stopped chan struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.ReportNow()
+		}
+	}
+}
+
+// ReportNow collects a Report and sends it to every Sink, waiting until all
+// of them are done or have timed out.
+func (r *Reporter) ReportNow() {
+	report := &Report{Snapshot: *r.store.snapshot(true)}
+
+	r.mtx.Lock()
+	r.reports += 1
+	sinks := append([]*reporterSink(nil), r.sinks...)
+	r.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sink := range sinks {
+		wg.Add(1)
+		go func(sink *reporterSink) {
+			defer wg.Done()
+			sink.send(report)
+		}(sink)
+	}
+	wg.Wait()
+}
+
+func (s *reporterSink) send(report *Report) {
+	var ctx context.Context
+	var cancel func()
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- s.sink.Send(ctx, report) }()
+
+	select {
+	case err := <-errs:
+		s.mtx.Lock()
+		if err != nil {
+			s.errors += 1
+		} else {
+			s.sent += 1
+		}
+		s.mtx.Unlock()
+		if err != nil {
+			logger.Errorf("sink %s failed: %s", s.name, err)
+		}
+	case <-ctx.Done():
+		s.mtx.Lock()
+		s.timeouts += 1
+		s.mtx.Unlock()
+		logger.Errorf("sink %s timed out", s.name)
+	}
+}
+
+// Stats conforms to the Monitor interface. It reports how many reports were
+// made, and how many each Sink sent, failed or timed out on.
+func (r *Reporter) Stats(cb func(name string, val float64)) {
+	r.mtx.Lock()
+	reports := r.reports
+	sinks := append([]*reporterSink(nil), r.sinks...)
+	r.mtx.Unlock()
+
+	cb("reports", float64(reports))
+	for _, sink := range sinks {
+		sink.mtx.Lock()
+		sent, errors, timeouts := sink.sent, sink.errors, sink.timeouts
+		sink.mtx.Unlock()
+		cb(sink.name+".errors", float64(errors))
+		cb(sink.name+".sent", float64(sent))
+		cb(sink.name+".timeouts", float64(timeouts))
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestReporter(t *testing.T) {
+	defer func(fraction float64) {
+		Config.DefaultCollectionFraction = fraction
+	}(Config.DefaultCollectionFraction)
+	Config.DefaultCollectionFraction = 1
+
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	reporter := NewReporter(store, time.Hour)
+
+	var reports []*Report
+	reporter.AddSink("memory", SinkFunc(
+		func(ctx context.Context, report *Report) error {
+			reports = append(reports, report)
+			return nil
+		}), 0)
+	reporter.AddSink("broken", SinkFunc(
+		func(ctx context.Context, report *Report) error {
+			return io.EOF
+		}), 0)
+	block := make(chan struct{})
+	defer close(block)
+	reporter.AddSink("slow", SinkFunc(
+		func(ctx context.Context, report *Report) error {
+			<-block
+			return nil
+		}), time.Millisecond)
+
+	reporter.Start()
+	mon.EventNamed("hits")
+	mon.Data("sizes", 3)
+	// stopping sends the last interval
+	reporter.Stop()
+	reporter.ReportNow()
+
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 reports, got %d", len(reports))
+	}
+	if len(reports[0].Points) != 1 ||
+		reports[0].Points[0].Name() != "foo.hits.count" {
+		t.Errorf("unexpected stats: %v", reports[0].Points)
+	}
+	if len(reports[0].Datasets) != 1 || reports[0].Datasets[0].Total != 1 {
+		t.Errorf("unexpected datasets: %v", reports[0].Datasets)
+	}
+	// datasets are drained by each report
+	if reports[1].Datasets[0].Total != 0 {
+		t.Errorf("datasets not reset: %v", reports[1].Datasets)
+	}
+
+	stats := Collect(reporter)
+	if stats["reports"] != 2 || stats["memory.sent"] != 2 ||
+		stats["broken.errors"] != 2 || stats["slow.timeouts"] != 2 {
+		t.Errorf("unexpected reporter stats: %v", stats)
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	report := &Report{Snapshot: Snapshot{
+		Time: time.Unix(1000, 0),
+		Points: []Stat{{
+			Group: "foo", Monitor: "hits", Stat: "count", Val: 2}}}}
+	sink := NewGraphiteSink(l.Addr().String(), "", false)
+	if err := sink.Send(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	if data := <-received; !strings.Contains(data, "foo.hits.count 2 1000\n") {
+		t.Errorf("unexpected data %q", data)
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stat is a single statistic, split into the parts of its full
+// group.monitor.stat name.
+type Stat struct {
+	Group   string
+	Monitor string
+	Stat    string
+	Tags    map[string]string
+	Val     float64
+}
+
+// Name returns the Stat's full name, as passed to a Monitor's Stats callback
+// with tags left out.
+func (s Stat) Name() string {
+	return fmt.Sprintf("%s.%s.%s", s.Group, s.Monitor, s.Stat)
+}
+
+// flatName returns the Stat's name as MonitorStore.Stats reports it, with any
+// tags folded into the monitor name.
+func (s Stat) flatName() string {
+	return fmt.Sprintf("%s.%s.%s", s.Group, taggedName(s.Monitor, s.Tags),
+		s.Stat)
+}
+
+// RunningTask is a task that was running when a Snapshot was taken.
+type RunningTask struct {
+	Group   string
+	Monitor string
+	Tags    map[string]string
+	Elapsed time.Duration
+}
+
+// Name returns the RunningTask's group.monitor name.
+func (t RunningTask) Name() string {
+	return fmt.Sprintf("%s.%s", t.Group, t.Monitor)
+}
+
+// flatName returns the RunningTask's name as MonitorStore.Running reports it.
+func (t RunningTask) flatName() string {
+	return fmt.Sprintf("%s.%s", t.Group, taggedName(t.Monitor, t.Tags))
+}
+
+// Dataset is the data a DatapointCollector kept, along with how it was
+// sampled.
+type Dataset struct {
+	Group     string
+	Collector string
+	Name      string
+	Tags      map[string]string
+	Data      [][]float64
+	Total     uint64
+	Clipped   bool
+	Fraction  float64
+}
+
+// FullName returns the Dataset's group.collector.name name, as passed to the
+// DataCollection Datapoints callback.
+func (d Dataset) FullName() string {
+	return fmt.Sprintf("%s.%s.%s", d.Group, d.Collector, d.Name)
+}
+
+// flatName returns the Dataset's name as MonitorStore.Datapoints reports it.
+func (d Dataset) flatName() string {
+	return fmt.Sprintf("%s.%s.%s", d.Group, taggedName(d.Collector, d.Tags),
+		d.Name)
+}
+
+// Snapshot is all of a MonitorStore's statistics, running tasks and datasets
+// at one point in time. Unlike the callback based Stats, Running and
+// Datapoints, a Snapshot can be kept, compared with another Snapshot, and
+// serialized. Snapshots encode to and decode from JSON.
+type Snapshot struct {
+	Time     time.Time
+	Points   []Stat
+	Running  []RunningTask
+	Datasets []Dataset
+}
+
+// Snapshot captures everything in the MonitorStore right now. Datasets are
+// copied, not reset.
+func (s *MonitorStore) Snapshot() *Snapshot {
+	return s.snapshot(false)
+}
+
+func (s *MonitorStore) snapshot(reset_datasets bool) *Snapshot {
+	return &Snapshot{
+		Time:     time.Now(),
+		Points:   s.collectStats(),
+		Running:  s.collectRunning(),
+		Datasets: s.collectDatasets(reset_datasets)}
+}
+
+// collectStats returns all of the MonitorStore's current statistics.
+func (s *MonitorStore) collectStats() (stats []Stat) {
+	s.eachGroup(func(group *MonitorGroup) {
+		stats = append(stats, group.collectStats()...)
+	})
+	return stats
+}
+
+// collectRunning returns all of the MonitorStore's running tasks, longest
+// running first.
+func (s *MonitorStore) collectRunning() (running []RunningTask) {
+	s.eachGroup(func(group *MonitorGroup) {
+		running = append(running, group.collectRunning()...)
+	})
+	sort.Sort(runningTasks(running))
+	return running
+}
+
+// collectDatasets returns all of the MonitorStore's datasets, resetting the
+// collectors if reset is true.
+func (s *MonitorStore) collectDatasets(reset bool) (datasets []Dataset) {
+	s.eachGroup(func(group *MonitorGroup) {
+		datasets = append(datasets, group.collectDatasets(reset)...)
+	})
+	return datasets
+}
+
+// collectStats returns all of the group's current statistics.
+func (g *MonitorGroup) collectStats() (stats []Stat) {
+	g.expireIdle()
+	g.eachMonitor(func(cache_key string, mon Monitor) {
+		name, tags := splitTaggedName(cache_key)
+		mon.Stats(func(subname string, val float64) {
+			stats = append(stats, Stat{
+				Group:   g.group_name,
+				Monitor: name,
+				Stat:    subname,
+				Tags:    tags,
+				Val:     val})
+		})
+	})
+	return stats
+}
+
+// collectRunning returns all of the group's running tasks, in monitor name
+// order.
+func (g *MonitorGroup) collectRunning() (running []RunningTask) {
+	g.eachMonitor(func(cache_key string, mon Monitor) {
+		task_monitor, ok := mon.(*TaskMonitor)
+		if !ok {
+			return
+		}
+		name, tags := splitTaggedName(cache_key)
+		for _, task := range task_monitor.Running() {
+			running = append(running, RunningTask{
+				Group:   g.group_name,
+				Monitor: name,
+				Tags:    tags,
+				Elapsed: task.ElapsedTime()})
+		}
+	})
+	return running
+}
+
+type runningTasks []RunningTask
+
+func (s runningTasks) Len() int      { return len(s) }
+func (s runningTasks) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s runningTasks) Less(i, j int) bool {
+	return s[i].Elapsed > s[j].Elapsed
+}
+
+// collectDatasets returns all of the group's datasets, resetting the
+// collectors if reset is true.
+func (g *MonitorGroup) collectDatasets(reset bool) (datasets []Dataset) {
+	snapshot := g.collectors.Snapshot()
+	for _, cache_key := range sortedStringKeys(snapshot) {
+		collector, ok := snapshot[cache_key].(DataCollection)
+		if !ok {
+			continue
+		}
+		name, tags := splitTaggedName(cache_key)
+		collector.Datapoints(reset, func(subname string, data [][]float64,
+			total uint64, clipped bool, fraction float64) {
+			datasets = append(datasets, Dataset{
+				Group:     g.group_name,
+				Collector: name,
+				Name:      subname,
+				Tags:      tags,
+				Data:      data,
+				Total:     total,
+				Clipped:   clipped,
+				Fraction:  fraction})
+		})
+	}
+	return datasets
+}
+
+// Stats conforms to the Monitor interface, so a Snapshot can be handed to
+// anything that takes a Monitor, like WriteGraphite.
+func (s *Snapshot) Stats(cb func(name string, val float64)) {
+	for _, stat := range s.Points {
+		cb(stat.flatName(), stat.Val)
+	}
+}
+
+// TaggedStats conforms to the TaggedMonitor interface.
+func (s *Snapshot) TaggedStats(
+	cb func(name string, tags map[string]string, val float64)) {
+	for _, stat := range s.Points {
+		cb(stat.Name(), stat.Tags, stat.Val)
+	}
+}
+
+// Filter returns a Snapshot with only the statistics, running tasks and
+// datasets whose names start with prefix.
+func (s *Snapshot) Filter(prefix string) *Snapshot {
+	rv := &Snapshot{Time: s.Time}
+	for _, stat := range s.Points {
+		if strings.HasPrefix(stat.Name(), prefix) {
+			rv.Points = append(rv.Points, stat)
+		}
+	}
+	for _, task := range s.Running {
+		if strings.HasPrefix(task.Name(), prefix) {
+			rv.Running = append(rv.Running, task)
+		}
+	}
+	for _, dataset := range s.Datasets {
+		if strings.HasPrefix(dataset.FullName(), prefix) {
+			rv.Datasets = append(rv.Datasets, dataset)
+		}
+	}
+	return rv
+}
+
+// StatDiff is how a statistic changed between two Snapshots. A statistic
+// that only exists in one of them has a zero value in the other, and
+// InBefore or InAfter unset.
+type StatDiff struct {
+	Group    string
+	Monitor  string
+	Stat     string
+	Tags     map[string]string
+	Before   float64
+	After    float64
+	InBefore bool
+	InAfter  bool
+}
+
+// Name returns the StatDiff's full name, like Stat.Name.
+func (d StatDiff) Name() string {
+	return fmt.Sprintf("%s.%s.%s", d.Group, d.Monitor, d.Stat)
+}
+
+// Delta returns how much the statistic went up by.
+func (d StatDiff) Delta() float64 {
+	return d.After - d.Before
+}
+
+// Diff compares the Snapshot with an earlier one, before, and returns every
+// statistic that changed, appeared or disappeared, in name order.
+func (s *Snapshot) Diff(before *Snapshot) []StatDiff {
+	diffs := make(map[string]*StatDiff)
+	var keys []string
+	diff := func(stat Stat) *StatDiff {
+		key := taggedName(stat.Name(), stat.Tags)
+		d, ok := diffs[key]
+		if !ok {
+			d = &StatDiff{
+				Group:   stat.Group,
+				Monitor: stat.Monitor,
+				Stat:    stat.Stat,
+				Tags:    stat.Tags}
+			diffs[key] = d
+			keys = append(keys, key)
+		}
+		return d
+	}
+	for _, stat := range before.Points {
+		d := diff(stat)
+		d.Before, d.InBefore = stat.Val, true
+	}
+	for _, stat := range s.Points {
+		d := diff(stat)
+		d.After, d.InAfter = stat.Val, true
+	}
+
+	sort.Strings(keys)
+	var rv []StatDiff
+	for _, key := range keys {
+		d := diffs[key]
+		if d.InBefore && d.InAfter && (d.Before == d.After ||
+			(d.Before != d.Before && d.After != d.After)) {
+			// unchanged, including NaN staying NaN
+			continue
+		}
+		rv = append(rv, *d)
+	}
+	return rv
+}
+
+// jsonSnapshot is the JSON form of a Snapshot. Each part is nested the same
+// way as in the HTTP handler's JSON views.
+type jsonSnapshot struct {
+	Time       time.Time      `json:"time"`
+	Stats      jsonStats      `json:"stats"`
+	Running    jsonRunning    `json:"running"`
+	Datapoints jsonDatapoints `json:"datapoints"`
+}
+
+// MarshalJSON conforms to the json.Marshaler interface
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSnapshot{
+		Time:       s.Time,
+		Stats:      newJSONStats(s.Points),
+		Running:    newJSONRunning(s.Running),
+		Datapoints: newJSONDatapoints(s.Datasets)})
+}
+
+// UnmarshalJSON conforms to the json.Unmarshaler interface
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var decoded jsonSnapshot
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	*s = Snapshot{
+		Time:     decoded.Time,
+		Points:   decoded.Stats.points(),
+		Running:  decoded.Running.tasks(),
+		Datasets: decoded.Datapoints.datasets()}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	defer func(fraction float64) {
+		Config.DefaultCollectionFraction = fraction
+	}(Config.DefaultCollectionFraction)
+	Config.DefaultCollectionFraction = 1
+
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("hits")
+	finish := mon.TaskNamed("slow")
+	defer finish(nil)
+	mon.Data("sizes", 1, 2)
+	store.GetMonitorsNamed("bar").EventNamed("misses")
+
+	before := store.Snapshot()
+	stats := Collect(before)
+	if stats["foo.hits.count"] != 1 || stats["bar.misses.count"] != 1 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+	if len(before.Running) != 1 || before.Running[0].Name() != "foo.slow" {
+		t.Errorf("unexpected running tasks: %v", before.Running)
+	}
+	if len(before.Datasets) != 1 ||
+		before.Datasets[0].FullName() != "foo.sizes.data" {
+		t.Errorf("unexpected datasets: %v", before.Datasets)
+	}
+
+	// taking a snapshot doesn't reset the datasets
+	if len(store.Snapshot().Datasets) != 1 {
+		t.Errorf("datasets were reset")
+	}
+
+	filtered := before.Filter("bar.")
+	if len(filtered.Points) != 1 || len(filtered.Running) != 0 ||
+		len(filtered.Datasets) != 0 {
+		t.Errorf("unexpected filtered snapshot: %v", filtered)
+	}
+
+	mon.EventNamed("hits")
+	mon.EventNamed("new")
+	diffs := store.Snapshot().Diff(before)
+	if len(diffs) != 2 {
+		t.Fatalf("unexpected diffs: %v", diffs)
+	}
+	if diffs[0].Name() != "foo.hits.count" || diffs[0].Delta() != 1 {
+		t.Errorf("unexpected diff: %v", diffs[0])
+	}
+	if diffs[1].Name() != "foo.new.count" || diffs[1].InBefore ||
+		!diffs[1].InAfter {
+		t.Errorf("unexpected diff: %v", diffs[1])
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	defer func(fraction float64) {
+		Config.DefaultCollectionFraction = fraction
+	}(Config.DefaultCollectionFraction)
+	Config.DefaultCollectionFraction = 1
+
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("hits")
+	mon.WithTags(map[string]string{"host": "a"}).EventNamed("hits")
+	finish := mon.TaskNamed("slow")
+	defer finish(nil)
+	mon.Data("sizes", 1, 2)
+
+	before := store.Snapshot()
+	data, err := json.Marshal(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var after Snapshot
+	err = json.Unmarshal(data, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.Time.Equal(before.Time) {
+		t.Errorf("time changed: %v != %v", after.Time, before.Time)
+	}
+	if diffs := after.Diff(before); len(diffs) != 0 {
+		t.Errorf("stats changed: %v", diffs)
+	}
+	if len(after.Running) != 1 || after.Running[0].Name() != "foo.slow" {
+		t.Errorf("unexpected running tasks: %v", after.Running)
+	}
+	if len(after.Datasets) != 1 || len(after.Datasets[0].Data) != 1 ||
+		after.Datasets[0].Data[0][1] != 2 {
+		t.Errorf("unexpected datasets: %v", after.Datasets)
+	}
+}
+
+func TestSnapshotTaggedNames(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("hits")
+	mon.WithTags(map[string]string{"method": "GET"}).Val("size", 3)
+
+	live := Collect(store)
+	snapshot := Collect(store.Snapshot())
+	if len(snapshot) != len(live) {
+		t.Errorf("snapshot has %d stats, store has %d", len(snapshot),
+			len(live))
+	}
+	for name, val := range live {
+		if got, ok := snapshot[name]; !ok || got != val {
+			t.Errorf("%s: snapshot has %f, store has %f", name, got, val)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultStackThreshold is how long a task has to have been running before
+// the HTTP handler's stacks view shows it, unless the request has a threshold
+// query parameter.
+const DefaultStackThreshold = 10 * time.Second
+
+var goroutinePrefix = []byte("goroutine ")
+
+// currentGoroutine returns the id of the calling goroutine, or 0 if it can't
+// be found.
+func currentGoroutine() int64 {
+	var buf [64]byte
+	return parseGoroutine(buf[:runtime.Stack(buf[:], false)])
+}
+
+// parseGoroutine returns the goroutine id from the start of a stack trace,
+// like "goroutine 12 [running]:", or 0 if there isn't one.
+func parseGoroutine(stack []byte) int64 {
+	if !bytes.HasPrefix(stack, goroutinePrefix) {
+		return 0
+	}
+	stack = stack[len(goroutinePrefix):]
+	end := bytes.IndexByte(stack, ' ')
+	if end < 0 {
+		return 0
+	}
+	id, err := strconv.ParseInt(string(stack[:end]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// currentStack returns the calling goroutine's stack trace.
+func currentStack() []byte {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+// goroutineStacks returns the stack traces of every goroutine, by id.
+func goroutineStacks() map[int64][]byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	stacks := make(map[int64][]byte)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if id := parseGoroutine(stack); id != 0 {
+			stacks[id] = stack
+		}
+	}
+	return stacks
+}
+
+type stackTask struct {
+	name    string
+	ctx     *TaskCtx
+	elapsed time.Duration
+}
+
+type stackTasks []stackTask
+
+func (s stackTasks) Len() int      { return len(s) }
+func (s stackTasks) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s stackTasks) Less(i, j int) bool {
+	return s[i].elapsed > s[j].elapsed
+}
+
+// WriteStacks writes every task that has been running for at least threshold
+// to w, longest running first, along with the current stack trace of the
+// goroutine that started it. Tasks that captured a stack when they started
+// (see Config.TaskStackFraction) have that written too. A task whose
+// goroutine handed it off to another and exited has no current stack.
+func (s *MonitorStore) WriteStacks(w io.Writer, threshold time.Duration) error {
+	_, err := s.writeStacks(w, &storeView{kind: viewStacks,
+		match: func(string) bool { return true }}, threshold)
+	return err
+}
+
+func (s *MonitorStore) writeStacks(w io.Writer, view *storeView,
+	threshold time.Duration) (found bool, err error) {
+	var tasks []stackTask
+	found = view.eachGroup(s, func(group *MonitorGroup) {
+		group.eachMonitor(func(cache_key string, mon Monitor) {
+			task_monitor, ok := mon.(*TaskMonitor)
+			if !ok {
+				return
+			}
+			name := fmt.Sprintf("%s.%s", group.group_name, cache_key)
+			if !view.match(name) {
+				return
+			}
+			for _, ctx := range task_monitor.Running() {
+				elapsed := ctx.ElapsedTime()
+				if elapsed >= threshold {
+					tasks = append(tasks, stackTask{
+						name: name, ctx: ctx, elapsed: elapsed})
+				}
+			}
+		})
+	})
+	if !found || len(tasks) == 0 {
+		return found, nil
+	}
+	sort.Sort(stackTasks(tasks))
+
+	stacks := goroutineStacks()
+	bw := bufio.NewWriter(w)
+	for _, task := range tasks {
+		goroutine := task.ctx.Goroutine()
+		fmt.Fprintf(bw, "%s\t%s\tgoroutine %d\n", task.elapsed, task.name,
+			goroutine)
+		if goroutine == 0 {
+			fmt.Fprintf(bw, "\tgoroutine unknown, see Config.TaskGoroutines\n")
+		} else if stack, ok := stacks[goroutine]; ok {
+			writeIndented(bw, stack)
+		} else {
+			fmt.Fprintf(bw, "\tgoroutine %d has exited\n", goroutine)
+		}
+		if start_stack := task.ctx.StartStack(); start_stack != nil {
+			fmt.Fprintf(bw, "\tstarted at:\n")
+			writeIndented(bw, start_stack)
+		}
+		fmt.Fprintln(bw)
+	}
+	return true, bw.Flush()
+}
+
+func writeIndented(w io.Writer, text []byte) {
+	for _, line := range bytes.Split(bytes.TrimRight(text, "\n"), []byte("\n")) {
+		fmt.Fprintf(w, "\t%s\n", line)
+	}
+}
+
+// stackThreshold returns the stacks view's threshold from the threshold
+// query parameter, which is a duration like "30s".
+func stackThreshold(req *http.Request) (time.Duration, error) {
+	param := req.URL.Query().Get("threshold")
+	if param == "" {
+		return DefaultStackThreshold, nil
+	}
+	threshold, err := time.ParseDuration(param)
+	if err != nil {
+		return 0, Error.New("invalid threshold %q: %s", param, err)
+	}
+	return threshold, nil
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hangingTask(mon *MonitorGroup, started, done chan struct{}) {
+	defer mon.TaskNamed("hang")(nil)
+	close(started)
+	<-done
+}
+
+func TestStacks(t *testing.T) {
+	defer func(fraction float64) {
+		Config.TaskStackFraction = fraction
+	}(Config.TaskStackFraction)
+	Config.TaskStackFraction = 1
+
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	started, done := make(chan struct{}), make(chan struct{})
+	defer close(done)
+	go hangingTask(mon, started, done)
+	<-started
+
+	cached, ok := mon.monitors.Lookup("hang")
+	if !ok {
+		t.Fatal("task monitor missing")
+	}
+	running := cached.(*TaskMonitor).Running()
+	if len(running) != 1 || running[0].Goroutine() == 0 ||
+		running[0].Goroutine() == currentGoroutine() {
+		t.Fatalf("unexpected goroutine: %v", running)
+	}
+
+	var buf bytes.Buffer
+	if err := store.WriteStacks(&buf, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("no task has run for an hour: %s", buf.String())
+	}
+
+	_, lines := serveText(t, store, "/stacks/foo?threshold=0s")
+	output := strings.Join(lines, "\n")
+	if !strings.Contains(lines[0], "\tfoo.hang\tgoroutine ") ||
+		strings.Count(output, ".hangingTask(") != 2 ||
+		!strings.Contains(output, "\tstarted at:") {
+		t.Errorf("unexpected stacks:\n%s", output)
+	}
+}
+
+func TestTaskGoroutine(t *testing.T) {
+	defer func(goroutines bool) {
+		Config.TaskGoroutines = goroutines
+	}(Config.TaskGoroutines)
+
+	tm := NewTaskMonitor()
+	if goroutine := tm.NewContext().Goroutine(); goroutine != 0 {
+		t.Errorf("goroutine found without asking: %d", goroutine)
+	}
+	Config.TaskGoroutines = true
+	if goroutine := tm.NewContext().Goroutine(); goroutine !=
+		currentGoroutine() {
+		t.Errorf("wrong goroutine %d", goroutine)
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// StatsDPacketSize is the largest UDP payload a StatsDClient sends by
+	// default. It keeps packets under a 1500 byte Ethernet MTU after IP and
+	// UDP headers.
+	StatsDPacketSize = 1432
+
+	statsdFlushInterval = 100 * time.Millisecond
+)
+
+// StatsDClient sends metrics to a StatsD or DogStatsD agent over UDP. Metrics
+// are batched into packets of up to StatsDPacketSize bytes, which are sent
+// when full and otherwise every 100 milliseconds.
+//
+// A StatsDClient can be used in two ways. Attached to a MonitorGroup with
+// SetStatsD, it forwards every event, value and task completion as it
+// happens. With Report, it periodically sends a Monitor's aggregated stats
+// as gauges. Both can be used at once.
+type StatsDClient struct {
+	conn        *net.UDPConn
+	prefix      string
+	dogstatsd   bool
+	packet_size int
+	done        chan struct{}
+	closing     sync.Once
+
+	mtx sync.Mutex
+	buf []byte
+}
+
+// NewStatsDClient creates a StatsDClient that sends to the StatsD agent at
+// statsd_addr, typically "127.0.0.1:8125". If prefix isn't empty, it's added
+// to the front of every metric name. Tags are written into the metric name
+// using Graphite's name;key=value syntax.
+func NewStatsDClient(statsd_addr, prefix string) (*StatsDClient, error) {
+	return newStatsDClient(statsd_addr, prefix, false)
+}
+
+// NewDogStatsDClient works like NewStatsDClient, but sends tags using the
+// DogStatsD |#key:value extension.
+func NewDogStatsDClient(statsd_addr, prefix string) (*StatsDClient, error) {
+	return newStatsDClient(statsd_addr, prefix, true)
+}
+
+func newStatsDClient(statsd_addr, prefix string, dogstatsd bool) (
+	*StatsDClient, error) {
+	addr, err := net.ResolveUDPAddr("udp", statsd_addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &StatsDClient{
+		conn:        conn,
+		prefix:      SanitizeName(prefix),
+		dogstatsd:   dogstatsd,
+		packet_size: StatsDPacketSize,
+		done:        make(chan struct{})}
+	go c.flushPeriodically()
+	return c, nil
+}
+
+// SetPacketSize changes the largest UDP payload the StatsDClient sends.
+// Raise it if the path to the agent supports jumbo frames or loopback sized
+// packets.
+func (c *StatsDClient) SetPacketSize(size int) {
+	c.mtx.Lock()
+	c.packet_size = size
+	c.mtx.Unlock()
+}
+
+// Count sends a counter increment of n.
+func (c *StatsDClient) Count(name string, tags map[string]string, n int64) {
+	c.add(name, tags, strconv.FormatInt(n, 10), "c")
+}
+
+// Gauge sends a gauge value.
+func (c *StatsDClient) Gauge(name string, tags map[string]string,
+	val float64) {
+	gauge(c.add, name, tags, val)
+}
+
+// gauge sends a gauge value with add.
+func gauge(add func(name string, tags map[string]string, val, kind string),
+	name string, tags map[string]string, val float64) {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return
+	}
+	if val < 0 {
+		// StatsD reads a signed gauge as a change to the current value, so
+		// negative gauges have to be set to zero first.
+		add(name, tags, "0", "g")
+	}
+	add(name, tags, strconv.FormatFloat(val, 'f', -1, 64), "g")
+}
+
+// Timing sends a timer value, in milliseconds.
+func (c *StatsDClient) Timing(name string, tags map[string]string,
+	duration time.Duration) {
+	c.add(name, tags, strconv.FormatFloat(
+		duration.Seconds()*1000, 'f', -1, 64), "ms")
+}
+
+// Report sends all of mon's stats as gauges every interval until the
+// StatsDClient is closed.
+func (c *StatsDClient) Report(mon Monitor, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				c.ReportOnce(mon)
+			}
+		}
+	}()
+}
+
+// ReportOnce sends all of mon's current stats as gauges.
+func (c *StatsDClient) ReportOnce(mon Monitor) {
+	if tagged, ok := mon.(TaggedMonitor); ok {
+		tagged.TaggedStats(c.Gauge)
+		return
+	}
+	mon.Stats(func(name string, val float64) { c.Gauge(name, nil, val) })
+}
+
+// Send conforms to the Sink interface, so a StatsDClient can be added to a
+// Reporter. It sends the report's stats as gauges, in packets of their own
+// whose writes give up at ctx's deadline. Forwarded metrics aren't held to
+// that deadline.
+func (c *StatsDClient) Send(ctx context.Context, report *Report) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	var buf []byte
+	var err error
+	add := func(name string, tags map[string]string, val, kind string) {
+		line := c.format(name, tags, val, kind)
+		c.mtx.Lock()
+		if batch_err := c.batch(&buf, line, deadline); err == nil {
+			err = batch_err
+		}
+		c.mtx.Unlock()
+	}
+	report.TaggedStats(func(name string, tags map[string]string,
+		val float64) {
+		gauge(add, name, tags, val)
+	})
+	c.mtx.Lock()
+	if write_err := c.write(&buf, deadline); err == nil {
+		err = write_err
+	}
+	c.mtx.Unlock()
+	return err
+}
+
+// Flush sends any batched metrics right away.
+func (c *StatsDClient) Flush() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.flush()
+}
+
+// Close flushes any batched metrics and closes the StatsDClient. Closing it
+// again does nothing.
+func (c *StatsDClient) Close() (err error) {
+	c.closing.Do(func() {
+		close(c.done)
+		err = c.Flush()
+		if close_err := c.conn.Close(); err == nil {
+			err = close_err
+		}
+	})
+	return err
+}
+
+func (c *StatsDClient) flushPeriodically() {
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.Flush(); err != nil {
+				logger.Errorf("statsd write error: %s", err)
+			}
+		}
+	}
+}
+
+// add batches a metric line, sending the current batch first if the line
+// wouldn't fit.
+func (c *StatsDClient) add(name string, tags map[string]string,
+	val, kind string) {
+	line := c.format(name, tags, val, kind)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if err := c.batch(&c.buf, line, time.Time{}); err != nil {
+		logger.Errorf("statsd write error: %s", err)
+	}
+}
+
+// batch appends line to buf, first sending buf if the line wouldn't fit.
+// c.mtx must be held.
+func (c *StatsDClient) batch(buf *[]byte, line []byte,
+	deadline time.Time) (err error) {
+	if len(*buf) > 0 && len(*buf)+1+len(line) > c.packet_size {
+		err = c.write(buf, deadline)
+	}
+	if len(*buf) > 0 {
+		*buf = append(*buf, '\n')
+	}
+	*buf = append(*buf, line...)
+	return err
+}
+
+func (c *StatsDClient) flush() error {
+	return c.write(&c.buf, time.Time{})
+}
+
+// write sends buf as one packet and empties it. A write with a deadline
+// resets it afterwards, and c.mtx must be held, so the deadline never applies
+// to any other write.
+func (c *StatsDClient) write(buf *[]byte, deadline time.Time) error {
+	if len(*buf) == 0 {
+		return nil
+	}
+	defer func() { *buf = (*buf)[:0] }()
+	if !deadline.IsZero() {
+		if err := c.conn.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	_, err := c.conn.Write(*buf)
+	return err
+}
+
+func (c *StatsDClient) format(name string, tags map[string]string,
+	val, kind string) []byte {
+	if c.prefix != "" {
+		name = c.prefix + "." + name
+	}
+	if !c.dogstatsd {
+		return []byte(graphitePath(name, tags) + ":" + val + "|" + kind)
+	}
+	line := []byte(name + ":" + val + "|" + kind)
+	if len(tags) > 0 {
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		line = append(line, "|#"...)
+		for i, key := range keys {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line = append(line, key+":"+tags[key]...)
+		}
+	}
+	return line
+}
+
+// SetStatsD makes the MonitorGroup forward every event, mark, value and task
+// completion to client as it happens, in addition to aggregating it. Events
+// and marks are sent as counters, values as gauges, and tasks as timers, with
+// an additional <name>.errors counter for tasks that fail. A nil client stops
+// forwarding.
+func (self *MonitorGroup) SetStatsD(client *StatsDClient) {
+	self.mtx.Lock()
+	self.statsd = client
+	self.mtx.Unlock()
+}
+
+// SetStatsD calls SetStatsD on every MonitorGroup in the store, and on every
+// MonitorGroup the store creates afterwards.
+func (s *MonitorStore) SetStatsD(client *StatsDClient) {
+	s.mtx.Lock()
+	s.statsd = client
+	s.mtx.Unlock()
+	s.eachGroup(func(group *MonitorGroup) { group.SetStatsD(client) })
+}
+
+func (self *MonitorGroup) getStatsD() *StatsDClient {
+	self.mtx.Lock()
+	client := self.statsd
+	self.mtx.Unlock()
+	return client
+}
+
+// statsdName returns the StatsD name and tags for the monitor stored under
+// name.
+func (self *MonitorGroup) statsdName(name string) (string, map[string]string) {
+	name, tags := splitTaggedName(name)
+	return self.group_name + "." + name, tags
+}
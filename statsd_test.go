@@ -0,0 +1,178 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func listenUDP(t *testing.T) *net.UDPConn {
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readPacket(t *testing.T, conn *net.UDPConn) string {
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestStatsDForwarding(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+	client, err := NewDogStatsDClient(conn.LocalAddr().String(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	store := NewMonitorStore()
+	store.SetStatsD(client)
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("hits")
+	mon.WithTags(map[string]string{"method": "GET"}).Val("size", -3)
+	func() {
+		var err error
+		defer mon.TaskNamed("bar")(&err)
+		err = io.EOF
+	}()
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(readPacket(t, conn), "\n")
+	if len(lines) != 5 ||
+		lines[0] != "app.foo.hits:1|c" ||
+		lines[1] != "app.foo.size:0|g|#method:GET" ||
+		lines[2] != "app.foo.size:-3|g|#method:GET" ||
+		!strings.HasPrefix(lines[3], "app.foo.bar:") ||
+		!strings.HasSuffix(lines[3], "|ms") ||
+		lines[4] != "app.foo.bar.errors:1|c" {
+		t.Errorf("unexpected packet %q", lines)
+	}
+}
+
+func TestStatsDCardinalityLimit(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+	client, err := NewStatsDClient(conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	mon := NewMonitorGroup("foo")
+	mon.SetStatsD(client)
+	mon.SetCardinalityLimit(1)
+	mon.EventNamed("user_1")
+	mon.EventNamed("user_2")
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rejected name is forwarded under the overflow name, too
+	lines := strings.Split(readPacket(t, conn), "\n")
+	if len(lines) != 2 || lines[0] != "foo.user_1:1|c" ||
+		lines[1] != "foo.cardinality_overflow_event:1|c" {
+		t.Errorf("unexpected packet %q", lines)
+	}
+}
+
+func TestStatsDBatching(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+	client, err := NewStatsDClient(conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetPacketSize(64)
+
+	mon := NewMonitorGroup("foo")
+	mon.Val("size", 3)
+	client.ReportOnce(mon)
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for len(lines) < 7 {
+		packet := readPacket(t, conn)
+		if len(packet) > 64 {
+			t.Errorf("packet too large: %q", packet)
+		}
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	if lines[0] != "foo.size.avg:3|g" || lines[6] != "foo.size.sum_squared:9|g" {
+		t.Errorf("unexpected lines %q", lines)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("unexpected close error: %s", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("unexpected second close error: %s", err)
+	}
+}
+
+func TestStatsDSend(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+	client, err := NewStatsDClient(conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	report := &Report{Snapshot: Snapshot{Points: []Stat{{
+		Group: "foo", Monitor: "hits", Stat: "count", Val: 2}}}}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		20*time.Millisecond)
+	defer cancel()
+	if err := client.Send(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+	if packet := readPacket(t, conn); packet != "foo.hits.count:2|g" {
+		t.Errorf("unexpected packet %q", packet)
+	}
+
+	// the send's deadline doesn't outlive it
+	<-ctx.Done()
+	client.Count("foo.misses", nil, 1)
+	if err := client.Flush(); err != nil {
+		t.Fatalf("flush failed after the send's deadline: %s", err)
+	}
+	if packet := readPacket(t, conn); packet != "foo.misses:1|c" {
+		t.Errorf("unexpected packet %q", packet)
+	}
+}
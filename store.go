@@ -15,6 +15,9 @@
 package monitor
 
 import (
+	"sync"
+	"time"
+
 	"github.com/spacemonkeygo/errors"
 	"gopkg.in/spacemonkeygo/monitor.v1/utils"
 )
@@ -22,7 +25,19 @@ import (
 // MonitorStore is a collection of package-level MonitorGroups. There is
 // typically only one MonitorStore per process, the DefaultStore.
 type MonitorStore struct {
+	// size is the number of monitors and collectors in all of the groups,
+	// accessed atomically. It stays first in the struct to keep it 64-bit
+	// aligned.
+	size   int64
 	groups *utils.ThreadsafeCache
+
+	mtx               sync.Mutex
+	idle_expiry       time.Duration
+	cardinality_limit int
+	statsd            *StatsDClient
+	classifier        ErrorClassifier
+	timing_buckets    []float64
+	history           *statHistory
 }
 
 // NewMonitorStore creates a new MonitorStore
@@ -43,6 +58,20 @@ func (s *MonitorStore) Stats(cb func(name string, val float64)) {
 	}
 }
 
+// TaggedStats conforms to the TaggedMonitor interface
+func (s *MonitorStore) TaggedStats(
+	cb func(name string, tags map[string]string, val float64)) {
+	snapshot := s.groups.Snapshot()
+	for _, name := range sortedStringKeys(snapshot) {
+		cache_val := snapshot[name]
+		mon, ok := cache_val.(TaggedMonitor)
+		if !ok {
+			continue
+		}
+		mon.TaggedStats(cb)
+	}
+}
+
 // Running collects lists of all running tasks by name
 func (s *MonitorStore) Running(cb func(name string, current []*TaskCtx)) {
 	snapshot := s.groups.Snapshot()
@@ -72,7 +101,15 @@ func (s *MonitorStore) Datapoints(reset bool, cb func(name string,
 func (s *MonitorStore) GetMonitorsNamed(group_name string) *MonitorGroup {
 	group_name = SanitizeName(group_name)
 	cached, err := s.groups.Get(group_name, func(_ interface{}) (interface{}, error) {
-		return NewMonitorGroup(group_name), nil
+		group := NewMonitorGroup(group_name)
+		group.store = s
+		s.mtx.Lock()
+		group.idle_expiry = s.idle_expiry
+		group.statsd = s.statsd
+		group.classifier = s.classifier
+		group.timing_buckets = s.timing_buckets
+		s.mtx.Unlock()
+		return group, nil
 	})
 	if err != nil {
 		// GetMonitor is often used to initialize global variables, so i'm
@@ -96,3 +133,4 @@ func (s *MonitorStore) GetMonitors() *MonitorGroup {
 }
 
 var _ RunningTasksCollector = (*MonitorStore)(nil)
+var _ TaggedMonitor = (*MonitorStore)(nil)
@@ -0,0 +1,105 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"sort"
+	"strings"
+)
+
+// TaggedMonitorGroup is a view of a MonitorGroup whose monitors all carry the
+// same set of key/value tags. Monitors made through a TaggedMonitorGroup live
+// in the MonitorGroup it came from, alongside untagged monitors by the same
+// name. The Stats callback sees them with the tags flattened into the
+// monitor name, like group.requests,method=GET.count, while the TaggedStats
+// callback sees the tags separately.
+type TaggedMonitorGroup struct {
+	group *MonitorGroup
+	tags  map[string]string
+}
+
+// WithTags returns a view of the MonitorGroup that tags every monitor it
+// makes with tags.
+func (self *MonitorGroup) WithTags(tags map[string]string) *TaggedMonitorGroup {
+	return &TaggedMonitorGroup{group: self, tags: sanitizeTags(nil, tags)}
+}
+
+// WithTags returns a view of the same MonitorGroup with both the existing
+// tags and tags. Values in tags override existing values for the same key.
+func (self *TaggedMonitorGroup) WithTags(
+	tags map[string]string) *TaggedMonitorGroup {
+	return &TaggedMonitorGroup{
+		group: self.group,
+		tags:  sanitizeTags(self.tags, tags)}
+}
+
+// Tags returns a copy of the tags this TaggedMonitorGroup applies.
+func (self *TaggedMonitorGroup) Tags() map[string]string {
+	return sanitizeTags(self.tags, nil)
+}
+
+// name returns the monitor name for name with this view's tags attached.
+func (self *TaggedMonitorGroup) name(name string) string {
+	return taggedName(SanitizeName(name), self.tags)
+}
+
+func sanitizeTags(existing, tags map[string]string) map[string]string {
+	rv := make(map[string]string, len(existing)+len(tags))
+	for key, val := range existing {
+		rv[key] = val
+	}
+	for key, val := range tags {
+		rv[SanitizeName(key)] = SanitizeName(val)
+	}
+	return rv
+}
+
+// taggedName flattens name and its tags into a single monitor name of the
+// form name,key1=val1,key2=val2 with the keys in sorted order. SanitizeName
+// never leaves commas or equals signs behind, so the result can always be
+// split apart again with splitTaggedName.
+func taggedName(name string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys)+1)
+	parts = append(parts, name)
+	for _, key := range keys {
+		parts = append(parts, key+"="+tags[key])
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitTaggedName undoes taggedName. Untagged names have nil tags.
+func splitTaggedName(name string) (string, map[string]string) {
+	parts := strings.Split(name, ",")
+	if len(parts) == 1 {
+		return name, nil
+	}
+	tags := make(map[string]string, len(parts)-1)
+	for _, part := range parts[1:] {
+		idx := strings.Index(part, "=")
+		if idx < 0 {
+			continue
+		}
+		tags[part[:idx]] = part[idx+1:]
+	}
+	return parts[0], tags
+}
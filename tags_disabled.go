@@ -0,0 +1,54 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build no_mon
+
+package monitor
+
+import (
+	"golang.org/x/net/context"
+)
+
+func (self *TaggedMonitorGroup) Data(name string, val ...float64)   {}
+func (self *TaggedMonitorGroup) Event(name string)                  {}
+func (self *TaggedMonitorGroup) EventNamed(name string)             {}
+func (self *TaggedMonitorGroup) Mark(name string)                   {}
+func (self *TaggedMonitorGroup) MarkN(name string, n int64)         {}
+func (self *TaggedMonitorGroup) Val(name string, val float64)       {}
+func (self *TaggedMonitorGroup) IntVal(name string, val int64)      {}
+func (self *TaggedMonitorGroup) Quantiles(name string, val float64) {}
+
+func (self *TaggedMonitorGroup) ValDescribed(name, description string,
+	val float64) {
+}
+
+func (self *TaggedMonitorGroup) IntValDescribed(name, description string,
+	val int64) {
+}
+
+func (self *TaggedMonitorGroup) Task() func(*error) { return func(*error) {} }
+
+func (self *TaggedMonitorGroup) TaskNamed(name string) func(*error) {
+	return func(*error) {}
+}
+
+func (self *TaggedMonitorGroup) TaskContext(
+	ctx context.Context) func(*error) {
+	return func(*error) {}
+}
+
+func (self *TaggedMonitorGroup) TaskNamedContext(ctx context.Context,
+	name string) func(*error) {
+	return func(*error) {}
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Task works like MonitorGroup.Task, but the TaskMonitor is tagged.
+func (self *TaggedMonitorGroup) Task() func(*error) {
+	return self.TaskNamed(shortCallerName(CallerName()))
+}
+
+// TaskNamed works like MonitorGroup.TaskNamed, but the TaskMonitor is tagged.
+func (self *TaggedMonitorGroup) TaskNamed(name string) func(*error) {
+	name = self.name(name)
+	task_monitor, name := self.group.taskMonitor(name)
+	if task_monitor == nil {
+		return func(*error) {}
+	}
+	return self.group.startTask(name, task_monitor, nil)
+}
+
+// TaskContext works like MonitorGroup.TaskContext, but the TaskMonitor is
+// tagged.
+func (self *TaggedMonitorGroup) TaskContext(ctx context.Context) func(*error) {
+	return self.TaskNamedContext(ctx, shortCallerName(CallerName()))
+}
+
+// TaskNamedContext works like MonitorGroup.TaskNamedContext, but the
+// TaskMonitor is tagged.
+func (self *TaggedMonitorGroup) TaskNamedContext(ctx context.Context,
+	name string) func(*error) {
+	name = self.name(name)
+	task_monitor, name := self.group.taskMonitor(name)
+	if task_monitor == nil {
+		return func(*error) {}
+	}
+	return self.group.startTask(name, task_monitor, ctx)
+}
+
+// Data works like MonitorGroup.Data, but the DatapointCollector is tagged.
+func (self *TaggedMonitorGroup) Data(name string, val ...float64) {
+	datapoint_collector := self.group.datapointCollector(self.name(name))
+	if datapoint_collector != nil {
+		datapoint_collector.Add(val...)
+	}
+}
+
+// Event works like MonitorGroup.Event, but the EventMonitor is tagged.
+func (self *TaggedMonitorGroup) Event(name string) {
+	self.EventNamed(shortCallerName(CallerName()) + "." + name)
+}
+
+// EventNamed works like MonitorGroup.EventNamed, but the EventMonitor is
+// tagged.
+func (self *TaggedMonitorGroup) EventNamed(name string) {
+	name = self.name(name)
+	event_monitor, name := self.group.eventMonitor(name)
+	if event_monitor != nil {
+		event_monitor.Add()
+		self.group.forwardCount(name, 1)
+	}
+}
+
+// Mark works like MonitorGroup.Mark, but the MeterMonitor is tagged.
+func (self *TaggedMonitorGroup) Mark(name string) {
+	self.MarkN(name, 1)
+}
+
+// MarkN works like MonitorGroup.MarkN, but the MeterMonitor is tagged.
+func (self *TaggedMonitorGroup) MarkN(name string, n int64) {
+	name = self.name(name)
+	meter_monitor, name := self.group.meterMonitor(name)
+	if meter_monitor != nil {
+		meter_monitor.Mark(n)
+		self.group.forwardCount(name, n)
+	}
+}
+
+// Val works like MonitorGroup.Val, but the ValueMonitor is tagged.
+func (self *TaggedMonitorGroup) Val(name string, val float64) {
+	self.ValDescribed(name, "", val)
+}
+
+// ValDescribed works like MonitorGroup.ValDescribed, but the ValueMonitor is
+// tagged.
+func (self *TaggedMonitorGroup) ValDescribed(name, description string,
+	val float64) {
+	name = self.name(name)
+	val_monitor, name := self.group.valueMonitor(name, description)
+	if val_monitor != nil {
+		val_monitor.Add(val)
+		self.group.forwardGauge(name, val)
+	}
+}
+
+// IntVal works like MonitorGroup.IntVal, but the IntValueMonitor is tagged.
+func (self *TaggedMonitorGroup) IntVal(name string, val int64) {
+	self.IntValDescribed(name, "", val)
+}
+
+// IntValDescribed works like MonitorGroup.IntValDescribed, but the
+// IntValueMonitor is tagged.
+func (self *TaggedMonitorGroup) IntValDescribed(name, description string,
+	val int64) {
+	name = self.name(name)
+	val_monitor, name := self.group.intValueMonitor(name, description)
+	if val_monitor != nil {
+		val_monitor.Add(val)
+		self.group.forwardGauge(name, float64(val))
+	}
+}
+
+// Quantiles works like MonitorGroup.Quantiles, but the QuantileMonitor is
+// tagged.
+func (self *TaggedMonitorGroup) Quantiles(name string, val float64) {
+	quantile_monitor := self.group.quantileMonitor(self.name(name))
+	if quantile_monitor != nil {
+		quantile_monitor.Add(val)
+	}
+}
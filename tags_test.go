@@ -0,0 +1,75 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTaggedMonitorGroup(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	mon.EventNamed("requests")
+	get := mon.WithTags(map[string]string{"method": "GET"})
+	get.EventNamed("requests")
+	get.EventNamed("requests")
+	get.WithTags(map[string]string{"tenant": "a,b=c"}).EventNamed("requests")
+
+	stats := Collect(store)
+	for name, val := range map[string]float64{
+		"foo.requests.count":                         1,
+		"foo.requests,method=GET.count":              2,
+		"foo.requests,method=GET,tenant=a_b_c.count": 1,
+	} {
+		if stats[name] != val {
+			t.Errorf("%s: got %f, want %f in %v", name, stats[name], val, stats)
+		}
+	}
+
+	var total float64
+	store.TaggedStats(func(name string, tags map[string]string, val float64) {
+		if name != "foo.requests.count" {
+			t.Errorf("unexpected name %q", name)
+		}
+		if tags["method"] == "GET" {
+			total += val
+		}
+	})
+	if total != 3 {
+		t.Errorf("tagged GET requests: got %f, want 3", total)
+	}
+
+	var buf bytes.Buffer
+	if err := store.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Count(out, "# TYPE foo_requests_count counter") != 1 {
+		t.Errorf("tagged monitors not merged into one family:\n%s", out)
+	}
+	for _, line := range []string{
+		"foo_requests_count 1\n",
+		`foo_requests_count{method="GET"} 2` + "\n",
+		`foo_requests_count{method="GET",tenant="a_b_c"} 1` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in output:\n%s", line, out)
+		}
+	}
+}
@@ -15,21 +15,30 @@
 package monitor
 
 import (
+	"container/list"
 	"sync"
 	"time"
 
 	"github.com/spacemonkeygo/monotime"
+	"golang.org/x/net/context"
 )
 
 // TaskMonitor is a type for keeping track of tasks. A TaskMonitor will keep
 // track of the current number of tasks, the highwater number (the maximum
 // amount of concurrent tasks), the total started, the total completed, the
 // total that returned without error, the average/min/max/most recent amount
-// of time the task took to succeed/fail/both, the number of different kinds
-// of errors the task had, and how many times the task had a panic.
+// of time the task took to succeed/fail/both, optionally a histogram and
+// percentiles of those times (see MonitorGroup.SetTimingBuckets), the number
+// of different kinds of errors the task had, and how many times the task had
+// a panic. Tasks started with a context.Context are also counted as canceled
+// or deadline_exceeded instead of failed when their context ended them, along
+// with how much time their deadlines allowed and how many of them outlived
+// their deadlines. Tasks a Watchdog finds stuck are counted as stuck.
 //
-// N.B.: Error types are best tracked when you're using Space Monkey's
-// hierarchical error package: http://github.com/spacemonkeygo/errors
+// Errors are put in buckets by DefaultErrorClassifier, which understands
+// Space Monkey's hierarchical error package
+// (http://github.com/spacemonkeygo/errors) as well as wrapped errors, errnos
+// and network timeouts. See SetErrorClassifier to change that.
 type TaskMonitor struct {
 	mtx             sync.Mutex
 	current         uint64
@@ -37,31 +46,171 @@ type TaskMonitor struct {
 	total_started   uint64
 	total_completed uint64
 	success         uint64
-	success_timing  *IntValueMonitor
-	error_timing    *IntValueMonitor
-	total_timing    *IntValueMonitor
+	success_timing  intValueAdder
+	error_timing    intValueAdder
+	total_timing    intValueAdder
+	success_hist    histogramAdder
+	error_hist      histogramAdder
+	total_hist      histogramAdder
+	started_meter   *MeterMonitor
+	completed_meter *MeterMonitor
+	error_meter     *MeterMonitor
 	errors          map[string]uint64
 	panics          uint64
+	stuck           uint64
 	running         map[*TaskCtx]bool
+	classifier      ErrorClassifier
+	values          map[string]*taskValue
+	split_values    bool
+
+	limited          bool
+	limit            int
+	holding          int
+	queued           int
+	queued_highwater int
+	rejected         uint64
+	queue_timeout    uint64
+	waiters          *list.List
+	queue_time       *ValueMonitor
+
+	context_tasks      uint64
+	canceled           uint64
+	deadline_exceeded  uint64
+	deadline_outlived  uint64
+	deadline_remaining *ValueMonitor
 }
 
-// NewTaskMonitor returns a new TaskMonitor. You probably want to create
-// a TaskMonitor using MonitorGroup.Task instead.
+// NewTaskMonitor returns a new TaskMonitor without timing histograms. You
+// probably want to create a TaskMonitor using MonitorGroup.Task instead.
 func NewTaskMonitor() *TaskMonitor {
-	return &TaskMonitor{
+	return NewTaskMonitorWithBuckets(nil)
+}
+
+// NewTaskMonitorWithBuckets returns a new TaskMonitor whose timing histograms
+// use the given bucket upper bounds, in seconds. If bounds is empty, no
+// histograms are kept.
+func NewTaskMonitorWithBuckets(bounds []float64) *TaskMonitor {
+	t := &TaskMonitor{
 		success_timing: NewIntValueMonitor(),
 		error_timing:   NewIntValueMonitor(),
 		total_timing:   NewIntValueMonitor(),
 		errors:         make(map[string]uint64),
-		running:        make(map[*TaskCtx]bool)}
+		running:        make(map[*TaskCtx]bool),
+		values:         make(map[string]*taskValue),
+		split_values:   Config.SplitTaskValues,
+
+		deadline_remaining: NewValueMonitor(),
+		queue_time:         NewValueMonitor()}
+	if len(bounds) > 0 {
+		t.success_hist = NewHistogram(bounds)
+		t.error_hist = NewHistogram(bounds)
+		t.total_hist = NewHistogram(bounds)
+	}
+	if Config.TaskMeters {
+		t.EnableMeters()
+	}
+	return t
+}
+
+// EnableMeters makes the TaskMonitor track the rates at which tasks start,
+// complete and fail, as MeterMonitors do. New TaskMonitors have meters
+// enabled when Config.TaskMeters is set.
+func (t *TaskMonitor) EnableMeters() {
+	t.mtx.Lock()
+	if t.started_meter == nil {
+		t.started_meter = NewMeterMonitor()
+		t.completed_meter = NewMeterMonitor()
+		t.error_meter = NewMeterMonitor()
+	}
+	t.mtx.Unlock()
+}
+
+// markStuck counts a task a Watchdog found stuck.
+func (t *TaskMonitor) markStuck() {
+	t.mtx.Lock()
+	t.stuck += 1
+	t.mtx.Unlock()
+}
+
+// taskValue aggregates the values recorded under one name on a TaskMonitor's
+// tasks. success and failure are only kept when values are split.
+type taskValue struct {
+	total   *ValueMonitor
+	success *ValueMonitor
+	failure *ValueMonitor
+}
+
+// SplitValues makes the TaskMonitor aggregate values recorded with
+// TaskCtx.Record separately for tasks that succeeded and tasks that failed,
+// in addition to all together. New TaskMonitors split values when
+// Config.SplitTaskValues is set.
+func (t *TaskMonitor) SplitValues() {
+	t.mtx.Lock()
+	t.split_values = true
+	t.mtx.Unlock()
 }
 
 // TaskCtx keeps track of a task as it is running.
 type TaskCtx struct {
-	start   time.Duration
-	monitor *TaskMonitor
+	start       time.Duration
+	monitor     *TaskMonitor
+	goroutine   int64
+	start_stack []byte
+	ctx         context.Context
+	on_finish   func(e *error, rec interface{})
+	values      map[string]float64
+}
+
+// isRunning returns whether any tasks are currently running.
+func (t *TaskMonitor) isRunning() bool {
+	t.mtx.Lock()
+	current := t.current
+	t.mtx.Unlock()
+	return current > 0
 }
 
 func (t TaskCtx) ElapsedTime() time.Duration {
 	return monotime.Monotonic() - t.start
 }
+
+// Goroutine returns the id of the goroutine that started the task, as it
+// appears in goroutine stack dumps, or 0 if it's unknown. It's only known for
+// tasks that captured a StartStack, or all tasks if Config.TaskGoroutines is
+// set, since finding it is expensive.
+func (t TaskCtx) Goroutine() int64 {
+	return t.goroutine
+}
+
+// StartStack returns the stack trace of the goroutine that started the task,
+// as of when it started. Only Config.TaskStackFraction of tasks capture one,
+// so it's usually nil.
+func (t TaskCtx) StartStack() []byte {
+	return t.start_stack
+}
+
+const (
+	contextCanceled         = "canceled"
+	contextDeadlineExceeded = "deadline_exceeded"
+)
+
+// contextErrorName returns contextCanceled or contextDeadlineExceeded if err
+// means a task ended because its context did, and "" otherwise. Any error
+// after ctx is done is blamed on ctx, and wrapped context errors are
+// unwrapped the same way the error classifier unwraps errors.
+func contextErrorName(ctx context.Context, err error) string {
+	if err == nil {
+		return ""
+	}
+	if ctx != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	for ; err != nil; err = unwrapError(err) {
+		switch err {
+		case context.Canceled:
+			return contextCanceled
+		case context.DeadlineExceeded:
+			return contextDeadlineExceeded
+		}
+	}
+	return ""
+}
@@ -16,11 +16,26 @@
 
 package monitor
 
+import (
+	"golang.org/x/net/context"
+)
+
 func (t *TaskMonitor) Stats(cb func(name string, val float64)) {}
 
 func (t *TaskMonitor) Start() func(*error)  { return func(*error) {} }
 func (t *TaskMonitor) NewContext() *TaskCtx { return &TaskCtx{} }
 
+func (t *TaskMonitor) StartContext(ctx context.Context) func(*error) {
+	return func(*error) {}
+}
+
+func (t *TaskMonitor) NewContextFor(ctx context.Context) *TaskCtx {
+	return &TaskCtx{}
+}
+
 func (c *TaskCtx) Finish(err_ref *error, rec interface{}) {}
 
 func (t *TaskMonitor) Running() (rv []*TaskCtx) { return nil }
+
+func (c *TaskCtx) Record(name string, val float64) {}
+func (c *TaskCtx) Done(err_ref *error)             {}
@@ -19,9 +19,12 @@ package monitor
 import (
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/spacemonkeygo/errors"
 	"github.com/spacemonkeygo/monotime"
+	"golang.org/x/net/context"
+	"gopkg.in/spacemonkeygo/monitor.v1/trace"
 )
 
 const (
@@ -38,18 +41,58 @@ func (t *TaskMonitor) Start() func(*error) {
 	return func(e *error) { ctx.Finish(e, recover()) }
 }
 
+// StartContext works like Start for a task that runs under ctx. If the task
+// fails after ctx is done, or fails with ctx's error, it's counted as
+// canceled or deadline_exceeded instead of as an error.
+func (t *TaskMonitor) StartContext(ctx context.Context) func(*error) {
+	c := t.NewContextFor(ctx)
+	return func(e *error) { c.Finish(e, recover()) }
+}
+
 // NewContext creates a new context that is watching a live task. See Start
 // or MonitorGroup.Task
 func (t *TaskMonitor) NewContext() *TaskCtx {
-	c := &TaskCtx{start: monotime.Monotonic(), monitor: t}
+	return t.newContext(nil)
+}
+
+// NewContextFor works like NewContext for a task that runs under ctx. See
+// StartContext or MonitorGroup.TaskContext
+func (t *TaskMonitor) NewContextFor(ctx context.Context) *TaskCtx {
+	return t.newContext(ctx)
+}
+
+func (t *TaskMonitor) newContext(ctx context.Context) *TaskCtx {
+	if ctx != nil {
+		if deadline, ok := ctx.Deadline(); ok {
+			t.deadline_remaining.Add(deadline.Sub(time.Now()).Seconds())
+		}
+	}
+	c := &TaskCtx{
+		start:   monotime.Monotonic(),
+		monitor: t,
+		ctx:     ctx}
+	if Config.TaskStackFraction > 0 &&
+		trace.Rng.Float64() < Config.TaskStackFraction {
+		c.start_stack = currentStack()
+		c.goroutine = parseGoroutine(c.start_stack)
+	} else if Config.TaskGoroutines {
+		c.goroutine = currentGoroutine()
+	}
 	t.mtx.Lock()
+	if ctx != nil {
+		t.context_tasks += 1
+	}
 	t.current += 1
 	t.total_started += 1
 	if t.current > t.highwater {
 		t.highwater = t.current
 	}
 	t.running[c] = true
+	started_meter := t.started_meter
 	t.mtx.Unlock()
+	if started_meter != nil {
+		started_meter.Mark(1)
+	}
 	return c
 }
 
@@ -62,11 +105,32 @@ func (t *TaskMonitor) Stats(cb func(name string, val float64)) {
 	total_completed := t.total_completed
 	success := t.success
 	panics := t.panics
+	stuck := t.stuck
+	started_meter := t.started_meter
+	completed_meter := t.completed_meter
+	error_meter := t.error_meter
+	context_tasks := t.context_tasks
+	canceled := t.canceled
+	deadline_exceeded := t.deadline_exceeded
+	deadline_outlived := t.deadline_outlived
+	limited := t.limited
+	limit := t.limit
+	queued := t.queued
+	queued_highwater := t.queued_highwater
+	rejected := t.rejected
+	queue_timeout := t.queue_timeout
 	error_counts := make(map[string]uint64, len(t.errors))
 	for error, count := range t.errors {
 		error_counts[error] = count
 	}
+	value_names := make([]string, 0, len(t.values))
+	values := make(map[string]taskValue, len(t.values))
+	for name, value := range t.values {
+		value_names = append(value_names, name)
+		values[name] = *value
+	}
 	t.mtx.Unlock()
+	sort.Strings(value_names)
 
 	errors := make([]string, 0, len(error_counts))
 	for error := range error_counts {
@@ -74,40 +138,114 @@ func (t *TaskMonitor) Stats(cb func(name string, val float64)) {
 	}
 	sort.Strings(errors)
 
+	// context stats are left out until they could mean something
+	context_stats := context_tasks > 0 || canceled > 0 || deadline_exceeded > 0
+	if context_stats {
+		cb("canceled", float64(canceled))
+	}
 	cb("current", float64(current))
+	if context_stats {
+		cb("deadline_exceeded", float64(deadline_exceeded))
+		cb("deadline_outlived", float64(deadline_outlived))
+		valueStats("deadline_remaining_", t.deadline_remaining, cb)
+	}
 	for _, error := range errors {
 		cb(fmt.Sprintf("error_%s", error), float64(error_counts[error]))
 	}
 	cb("highwater", float64(highwater))
+	if limited {
+		cb("limit", float64(limit))
+	}
 	cb("panics", float64(panics))
+	if limited {
+		valueStats("queue_time_", t.queue_time, cb)
+		cb("queue_timeout", float64(queue_timeout))
+		cb("queued", float64(queued))
+		cb("queued_highwater", float64(queued_highwater))
+	}
+	if started_meter != nil {
+		rateStats("rate_started_", started_meter, cb)
+		rateStats("rate_completed_", completed_meter, cb)
+		rateStats("rate_error_", error_meter, cb)
+	}
+	if limited {
+		cb("rejected", float64(rejected))
+	}
+	// only tasks a Watchdog has found stuck report it
+	if stuck > 0 {
+		cb("stuck", float64(stuck))
+	}
 	cb("success", float64(success))
 
 	if len(errors) > 0 {
-		t.error_timing.Stats(func(name string, val float64) {
-			if name != "count" {
-				// these values are in microseconds, convert to seconds
-				cb(fmt.Sprintf("time_error_%s", name), val/secondInMicroseconds)
-			}
-		})
+		timingStats("time_error_", t.error_timing, t.error_hist, cb)
 	}
 	if success > 0 {
-		t.success_timing.Stats(func(name string, val float64) {
-			if name != "count" {
-				// these values are in microseconds, convert to seconds
-				cb(fmt.Sprintf("time_success_%s", name), val/secondInMicroseconds)
-			}
-		})
+		timingStats("time_success_", t.success_timing, t.success_hist, cb)
 	}
 	if total_completed > 0 {
-		t.total_timing.Stats(func(name string, val float64) {
+		timingStats("time_total_", t.total_timing, t.total_hist, cb)
+	}
+	cb("total_completed", float64(total_completed))
+	cb("total_started", float64(total_started))
+	for _, name := range value_names {
+		value := values[name]
+		valueStats("value_"+name+"_", value.total, cb)
+		if value.success != nil {
+			valueStats("value_"+name+"_error_", value.failure, cb)
+			valueStats("value_"+name+"_success_", value.success, cb)
+		}
+	}
+}
+
+// valueStats reports value with every name prefixed by prefix, unless no
+// values have been added to it.
+func valueStats(prefix string, value *ValueMonitor,
+	cb func(name string, val float64)) {
+	value.mtx.Lock()
+	count := value.count
+	value.mtx.Unlock()
+	if count == 0 {
+		return
+	}
+	value.Stats(func(name string, val float64) {
+		cb(prefix+name, val)
+	})
+}
+
+// rateStats reports the rates from meter with every name prefixed by prefix.
+// The counts are left out, as the TaskMonitor reports its own totals.
+func rateStats(prefix string, meter *MeterMonitor,
+	cb func(name string, val float64)) {
+	meter.Stats(func(name string, val float64) {
+		if name != "count" {
+			cb(prefix+name, val)
+		}
+	})
+}
+
+// timingStats reports timing, which is in microseconds, and hist, which is
+// in seconds, with every name prefixed by prefix. Windowed timings report
+// their count, since it differs from the task's totals.
+func timingStats(prefix string, timing intValueAdder, hist histogramAdder,
+	cb func(name string, val float64)) {
+	_, windowed := timing.(*WindowedIntValueMonitor)
+	timing.Stats(func(name string, val float64) {
+		switch {
+		case name != "count":
+			// these values are in microseconds, convert to seconds
+			cb(prefix+name, val/secondInMicroseconds)
+		case windowed:
+			cb(prefix+name, val)
+		}
+	})
+	if hist != nil {
+		hist.Stats(func(name string, val float64) {
 			if name != "count" {
-				// these values are in microseconds, convert to seconds
-				cb(fmt.Sprintf("time_total_%s", name), val/secondInMicroseconds)
+				cb(prefix+name, val)
 			}
 		})
 	}
-	cb("total_completed", float64(total_completed))
-	cb("total_started", float64(total_started))
 }
 
 // Finish records a successful task completion. You must pass a pointer to
@@ -129,13 +267,18 @@ func (c *TaskCtx) Finish(err_ref *error, rec interface{}) {
 			err = errors.PanicError.New("%v", rec)
 		}
 	}
-	if err != nil {
-		error_name = errors.GetClass(err).String()
-		max_len := Config.MaxErrorLength
-		if len(error_name) > max_len {
-			error_name = error_name[:max_len]
-		}
-		error_name = SanitizeName(error_name)
+	context_error := ""
+	if rec == nil && c.ctx != nil {
+		context_error = contextErrorName(c.ctx, err)
+	}
+	failed := err != nil && context_error == ""
+	outlived := false
+	if c.ctx != nil {
+		deadline, ok := c.ctx.Deadline()
+		outlived = ok && time.Now().After(deadline)
+	}
+	if failed {
+		error_name = c.monitor.classify(err)
 	}
 
 	// we keep granularity on the order microseconds, which should keep
@@ -144,21 +287,64 @@ func (c *TaskCtx) Finish(err_ref *error, rec interface{}) {
 		microsecondInNanoseconds)
 
 	c.monitor.mtx.Lock()
+	values := make(map[*taskValue]float64, len(c.values))
+	for name, val := range c.values {
+		values[c.monitor.taskValue(name)] = val
+	}
 	c.monitor.current -= 1
 	c.monitor.total_completed += 1
 	delete(c.monitor.running, c)
-	if err != nil {
+	if outlived {
+		c.monitor.deadline_outlived += 1
+	}
+	switch {
+	case context_error == contextCanceled:
+		c.monitor.canceled += 1
+	case context_error == contextDeadlineExceeded:
+		c.monitor.deadline_exceeded += 1
+	case err != nil:
 		c.monitor.errors[error_name] += 1
 		if rec != nil {
 			c.monitor.panics += 1
 		}
 		c.monitor.error_timing.Add(duration_microseconds)
-	} else {
+	default:
 		c.monitor.success_timing.Add(duration_microseconds)
 		c.monitor.success += 1
 	}
+	completed_meter := c.monitor.completed_meter
+	error_meter := c.monitor.error_meter
 	c.monitor.mtx.Unlock()
 	c.monitor.total_timing.Add(duration_microseconds)
+	if completed_meter != nil {
+		completed_meter.Mark(1)
+		if failed {
+			error_meter.Mark(1)
+		}
+	}
+	if c.monitor.total_hist != nil {
+		duration_seconds := float64(duration_microseconds) /
+			secondInMicroseconds
+		if failed {
+			c.monitor.error_hist.Add(duration_seconds)
+		} else if err == nil {
+			c.monitor.success_hist.Add(duration_seconds)
+		}
+		c.monitor.total_hist.Add(duration_seconds)
+	}
+	for value, val := range values {
+		value.total.Add(val)
+		if value.success != nil {
+			if err == nil {
+				value.success.Add(val)
+			} else if failed {
+				value.failure.Add(val)
+			}
+		}
+	}
+	if c.on_finish != nil {
+		c.on_finish(err_ref, rec)
+	}
 
 	// doh, we didn't actually want to stop the panic codepath.
 	// we have to repanic. Oh and great, panics can be nil. Welp!
@@ -167,6 +353,54 @@ func (c *TaskCtx) Finish(err_ref *error, rec interface{}) {
 	}
 }
 
+// Record adds val to the value called name on the running task. When the task
+// finishes, each of its values is added to a ValueMonitor for that name on
+// the task's TaskMonitor, reported as value_<name>_<stat>. With SplitValues,
+// values are also added to value_<name>_success_<stat> or
+// value_<name>_error_<stat>, depending on how the task finished. Tasks that
+// end because their context did only count towards the total. Recording the
+// same name more than once in a task adds up the values.
+func (c *TaskCtx) Record(name string, val float64) {
+	if c.monitor == nil {
+		return
+	}
+	name = SanitizeName(name)
+	c.monitor.mtx.Lock()
+	if c.values == nil {
+		c.values = make(map[string]float64)
+	}
+	c.values[name] += val
+	c.monitor.mtx.Unlock()
+}
+
+// Done calls Finish with the result of recover(). It must be deferred
+// directly, like the functions MonitorGroup.Task returns.
+func (c *TaskCtx) Done(err_ref *error) {
+	rec := recover()
+	if c.monitor == nil {
+		if rec != nil {
+			panic(rec)
+		}
+		return
+	}
+	c.Finish(err_ref, rec)
+}
+
+// taskValue returns the taskValue that values recorded under name go in.
+// t.mtx must be held.
+func (t *TaskMonitor) taskValue(name string) *taskValue {
+	value, ok := t.values[name]
+	if !ok {
+		value = &taskValue{total: NewValueMonitor()}
+		t.values[name] = value
+	}
+	if t.split_values && value.success == nil {
+		value.success = NewValueMonitor()
+		value.failure = NewValueMonitor()
+	}
+	return value
+}
+
 // Running returns a list of tasks that are currently running. Each TaskCtx
 // can tell how long it's been since the task was started, though keep in mind
 // that the task might finish between calling (*TaskMonitor).Running() and
@@ -0,0 +1,146 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTaskContext(t *testing.T) {
+	mon := NewMonitorGroup("foo")
+	task := func(ctx context.Context, err error) {
+		defer mon.TaskNamedContext(ctx, "bar")(&err)
+	}
+
+	task(context.Background(), nil)
+	task(context.Background(), io.EOF)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	task(ctx, io.EOF)
+	task(ctx, nil)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	task(ctx, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithDeadline(context.Background(),
+		time.Now().Add(-time.Second))
+	defer cancel()
+	task(ctx, io.ErrUnexpectedEOF)
+
+	// wrapped context errors count too
+	task(context.Background(), fmt.Errorf("fetch: %w", context.Canceled))
+
+	stats := Collect(mon)
+	for name, expected := range map[string]float64{
+		"foo.bar.success":                  2,
+		"foo.bar.error_System_Error":       1,
+		"foo.bar.canceled":                 2,
+		"foo.bar.deadline_exceeded":        2,
+		"foo.bar.deadline_outlived":        1,
+		"foo.bar.deadline_remaining_count": 2,
+		"foo.bar.total_completed":          7,
+	} {
+		if stats[name] != expected {
+			t.Errorf("%s: %f != %f", name, stats[name], expected)
+		}
+	}
+	if max := stats["foo.bar.deadline_remaining_max"]; max < 3599 ||
+		max > 3600 {
+		t.Errorf("unexpected remaining deadline: %f", max)
+	}
+
+	// tasks whose contexts never had deadlines don't report the time left
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	task_err := error(io.EOF)
+	mon.TaskNamedContext(ctx, "qux")(&task_err)
+	stats = Collect(mon)
+	if stats["foo.qux.canceled"] != 1 {
+		t.Errorf("canceled task not counted: %v", stats)
+	}
+	if _, ok := stats["foo.qux.deadline_remaining_min"]; ok {
+		t.Errorf("deadline_remaining reported without deadlines: %v", stats)
+	}
+
+	// tasks without a context don't report context stats, and context
+	// errors they return are classified like any other error
+	mon.TaskNamed("baz")(nil)
+	err := fmt.Errorf("fetch: %w", context.Canceled)
+	mon.TaskNamed("baz")(&err)
+	stats = Collect(mon)
+	if _, ok := stats["foo.baz.canceled"]; ok {
+		t.Errorf("plain tasks shouldn't report canceled")
+	}
+	if stats["foo.baz.error_Canceled"] != 1 {
+		t.Errorf("plain task context error not classified: %v", stats)
+	}
+}
+
+func TestTaskValues(t *testing.T) {
+	defer func(split bool) { Config.SplitTaskValues = split }(
+		Config.SplitTaskValues)
+	Config.SplitTaskValues = true
+
+	mon := NewMonitorGroup("foo")
+	task := func(rows float64, err error) {
+		task := mon.NewTask("bar")
+		defer task.Done(&err)
+		task.Record("rows", rows/2)
+		task.Record("rows", rows/2)
+	}
+	task(10, nil)
+	task(30, nil)
+	task(4, io.EOF)
+
+	func() {
+		defer func() { recover() }()
+		task := mon.NewTask("bar")
+		defer task.Done(nil)
+		task.Record("rows", 6)
+		panic("oops")
+	}()
+
+	stats := Collect(mon)
+	for name, expected := range map[string]float64{
+		"foo.bar.value_rows_count":         4,
+		"foo.bar.value_rows_sum":           50,
+		"foo.bar.value_rows_max":           30,
+		"foo.bar.value_rows_success_count": 2,
+		"foo.bar.value_rows_success_avg":   20,
+		"foo.bar.value_rows_error_count":   2,
+		"foo.bar.value_rows_error_min":     4,
+		"foo.bar.success":                  2,
+		"foo.bar.panics":                   1,
+	} {
+		if stats[name] != expected {
+			t.Errorf("%s: %f != %f", name, stats[name], expected)
+		}
+	}
+
+	// tasks that never record a value don't report it
+	mon.TaskNamed("baz")(nil)
+	if _, ok := Collect(mon)["foo.baz.value_rows_count"]; ok {
+		t.Errorf("unrecorded values shouldn't be reported")
+	}
+}
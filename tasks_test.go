@@ -103,3 +103,10 @@ func ExampleTaskMonitor_Start(t *testing.T) {
 	}
 	myfunc()
 }
+
+func BenchmarkTask(b *testing.B) {
+	mon := NewMonitorGroup("foo")
+	for i := 0; i < b.N; i++ {
+		mon.TaskNamed("x")(nil)
+	}
+}
@@ -2,21 +2,26 @@ package trace
 
 import "time"
 
-// backoff implements a backoff policy, randomizing its delays and
+// Backoff implements a backoff policy, randomizing its delays and
 // saturating at its last value.
-type backoff struct {
+type Backoff struct {
 	millis []int
 }
 
-// defaultBackoff is a backoff policy ranging up to 5s.
-var defaultBackoff = backoff{
-	[]int{0, 10, 10, 100, 100, 500, 500, 3000, 3000, 5000},
+// NewBackoff makes a Backoff policy that waits around millis[n] milliseconds
+// on the n'th wait cycle.
+func NewBackoff(millis ...int) Backoff {
+	return Backoff{millis: millis}
 }
 
-// duration returns the time duration of the n'th wait cycle in its
+// DefaultBackoff is a backoff policy ranging up to 5s.
+var DefaultBackoff = NewBackoff(0, 10, 10, 100, 100, 500, 500, 3000, 3000,
+	5000)
+
+// Duration returns the time duration of the n'th wait cycle in its
 // backoff policy. This is backoff.millis[n], randomized to avoid
 // thundering herds.
-func (b backoff) duration(n int) time.Duration {
+func (b Backoff) Duration(n int) time.Duration {
 	if n >= len(b.millis) {
 		n = len(b.millis) - 1
 	}
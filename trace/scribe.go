@@ -70,7 +70,7 @@ func (s *ScribeCollector) pumpWrites() {
 		select {
 		case <-s.done:
 			return
-		case <-time.After(defaultBackoff.duration(backoff)):
+		case <-time.After(DefaultBackoff.Duration(backoff)):
 		}
 
 		conn, err := newScribeConn(s.addr)
@@ -16,59 +16,138 @@ package utils
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spacemonkeygo/monotime"
 )
 
+type cacheEntry struct {
+	// last_used is a monotonic time in nanoseconds, accessed atomically. It
+	// stays first in the struct to keep it 64-bit aligned.
+	last_used int64
+	val       interface{}
+}
+
+// touch records that the entry was just used. To avoid every user of a hot
+// entry writing to the same memory, the time is only updated when it has
+// drifted by more than a second.
+func (e *cacheEntry) touch(now int64) {
+	if now-atomic.LoadInt64(&e.last_used) > int64(time.Second) {
+		atomic.StoreInt64(&e.last_used, now)
+	}
+}
+
 type ThreadsafeCache struct {
 	mtx    sync.RWMutex
-	values map[interface{}]interface{}
+	values map[interface{}]*cacheEntry
 }
 
 func NewThreadsafeCache() *ThreadsafeCache {
 	return &ThreadsafeCache{
-		values: make(map[interface{}]interface{}),
+		values: make(map[interface{}]*cacheEntry),
 	}
 }
 
 func (c *ThreadsafeCache) Get(key interface{},
 	defaultcb func(key interface{}) (interface{}, error)) (interface{}, error) {
 
+	now := int64(monotime.Monotonic())
 	c.mtx.RLock()
-	val, ok := c.values[key]
+	entry, ok := c.values[key]
 	if ok {
+		// touched under the lock, so DropIdle can't drop an entry that's
+		// being handed out
+		entry.touch(now)
 		c.mtx.RUnlock()
-		return val, nil
+		return entry.val, nil
 	}
 	c.mtx.RUnlock()
 
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
-	val, ok = c.values[key]
+	entry, ok = c.values[key]
 	if ok {
-		return val, nil
+		entry.touch(now)
+		return entry.val, nil
 	}
 
 	val, err := defaultcb(key)
 	if err == nil {
-		c.values[key] = val
+		c.values[key] = &cacheEntry{last_used: now, val: val}
 	}
 
 	return val, err
 }
 
-func (c *ThreadsafeCache) Drop(key interface{}) {
+// Lookup returns the value for key without creating one if it's missing.
+func (c *ThreadsafeCache) Lookup(key interface{}) (interface{}, bool) {
+	now := int64(monotime.Monotonic())
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	entry, ok := c.values[key]
+	if !ok {
+		return nil, false
+	}
+	entry.touch(now)
+	return entry.val, true
+}
+
+// Len returns the number of values in the cache.
+func (c *ThreadsafeCache) Len() int {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return len(c.values)
+}
+
+// Drop removes key from the cache, and returns whether it was there.
+func (c *ThreadsafeCache) Drop(key interface{}) (dropped bool) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	_, dropped = c.values[key]
 	delete(c.values, key)
+	return dropped
+}
+
+// Contains returns whether key is in the cache, without counting as a use of
+// it.
+func (c *ThreadsafeCache) Contains(key interface{}) bool {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	_, ok := c.values[key]
+	return ok
+}
+
+// DropIdle drops every value that hasn't been retrieved with Get in the last
+// idle amount of time, unless keep returns true for it. keep may be nil. It
+// returns the keys of the values dropped.
+func (c *ThreadsafeCache) DropIdle(idle time.Duration,
+	keep func(key, val interface{}) bool) (dropped []interface{}) {
+	cutoff := int64(monotime.Monotonic() - idle)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for key, entry := range c.values {
+		if atomic.LoadInt64(&entry.last_used) >= cutoff {
+			continue
+		}
+		if keep != nil && keep(key, entry.val) {
+			continue
+		}
+		delete(c.values, key)
+		dropped = append(dropped, key)
+	}
+	return dropped
 }
 
 func (c *ThreadsafeCache) Snapshot() map[interface{}]interface{} {
 	c.mtx.RLock()
 	defer c.mtx.RUnlock()
 	snapshot := make(map[interface{}]interface{}, len(c.values))
-	for key, value := range c.values {
-		snapshot[key] = value
+	for key, entry := range c.values {
+		snapshot[key] = entry.val
 	}
 	return snapshot
 }
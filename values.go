@@ -29,6 +29,7 @@ type ValueMonitor struct {
 	sum_squared float64
 	max         float64
 	min         float64
+	description string
 }
 
 // NewValueMonitor creates a new ValueMonitor. You probably want to create a
@@ -87,6 +88,7 @@ type IntValueMonitor struct {
 	sum_squared int64
 	max         int64
 	min         int64
+	description string
 }
 
 // NewIntValueMonitor returns a new IntValueMonitor. You probably want to
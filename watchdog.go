@@ -0,0 +1,225 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StuckTask is a task a Watchdog found running for longer than its
+// threshold.
+type StuckTask struct {
+	Group     string
+	Monitor   string
+	Tags      map[string]string
+	Elapsed   time.Duration
+	Threshold time.Duration
+	Ctx       *TaskCtx
+}
+
+// Name returns the StuckTask's group.monitor name.
+func (t StuckTask) Name() string {
+	return fmt.Sprintf("%s.%s", t.Group, t.Monitor)
+}
+
+// Watchdog periodically looks through a MonitorStore's running tasks for
+// ones that have been running longer than they should. Each stuck task is
+// counted, logged and passed to the Watchdog's callbacks once, the first time
+// it's found.
+//
+// Each task monitor counts its own stuck tasks in its stuck stat. A Watchdog
+// is also a Monitor, reporting how many tasks it has found stuck in total and
+// how many are stuck right now, so it can be added to a MonitorGroup with
+// Chain to make alerting on stuck tasks possible:
+//
+//	watchdog := monitor.NewWatchdog(monitor.DefaultStore, time.Minute)
+//	watchdog.Start(10 * time.Second)
+//	monitor.GetMonitors().Chain("watchdog", watchdog)
+type Watchdog struct {
+	store             *MonitorStore
+	default_threshold time.Duration
+
+	mtx        sync.Mutex
+	thresholds map[string]time.Duration
+	callbacks  []func(task StuckTask)
+	found      map[*TaskCtx]bool
+	total      uint64
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// NewWatchdog makes a Watchdog for store that considers a task stuck once it
+// has been running for default_threshold, unless SetThreshold says otherwise
+// for that task.
+func NewWatchdog(store *MonitorStore,
+	default_threshold time.Duration) *Watchdog {
+	return &Watchdog{
+		store:             store,
+		default_threshold: default_threshold,
+		thresholds:        make(map[string]time.Duration),
+		found:             make(map[*TaskCtx]bool)}
+}
+
+// SetThreshold sets the threshold for the task monitor called name, in
+// "group.monitor" form, such as "main.handle_request". Tagged task monitors
+// use the threshold of their untagged name. A threshold of zero means tasks
+// by that name are never stuck.
+func (w *Watchdog) SetThreshold(name string, threshold time.Duration) {
+	w.mtx.Lock()
+	w.thresholds[SanitizeName(name)] = threshold
+	w.mtx.Unlock()
+}
+
+// OnStuck registers cb to be called with every stuck task found. Callbacks
+// are called one at a time from the Watchdog's goroutine, and should return
+// quickly.
+func (w *Watchdog) OnStuck(cb func(task StuckTask)) {
+	w.mtx.Lock()
+	w.callbacks = append(w.callbacks, cb)
+	w.mtx.Unlock()
+}
+
+// threshold returns the threshold for the task monitor called name.
+// w.mtx must be held.
+func (w *Watchdog) threshold(name string) time.Duration {
+	if threshold, ok := w.thresholds[name]; ok {
+		return threshold
+	}
+	return w.default_threshold
+}
+
+// Start starts checking for stuck tasks every interval in the background.
+func (w *Watchdog) Start(interval time.Duration) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.stop != nil {
+		return
+	}
+	w.stop = make(chan struct{})
+	w.stopped = make(chan struct{})
+	go w.run(interval, w.stop, w.stopped)
+}
+
+// Stop stops the Watchdog.
+func (w *Watchdog) Stop() {
+	w.mtx.Lock()
+	stop, stopped := w.stop, w.stopped
+	w.stop, w.stopped = nil, nil
+	w.mtx.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-stopped
+}
+
+func (w *Watchdog) run(interval time.Duration, stop, stopped chan struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.Check()
+		}
+	}
+}
+
+// Check looks for stuck tasks right away, and returns the ones that weren't
+// found stuck before.
+func (w *Watchdog) Check() (stuck []StuckTask) {
+	running := make(map[*TaskCtx]bool)
+	w.mtx.Lock()
+	w.store.eachGroup(func(group *MonitorGroup) {
+		group.eachMonitor(func(cache_key string, mon Monitor) {
+			task_monitor, ok := mon.(*TaskMonitor)
+			if !ok {
+				return
+			}
+			name, tags := splitTaggedName(cache_key)
+			threshold := w.threshold(group.group_name + "." + name)
+			for _, ctx := range task_monitor.Running() {
+				running[ctx] = true
+				if threshold <= 0 || w.found[ctx] {
+					continue
+				}
+				elapsed := ctx.ElapsedTime()
+				if elapsed < threshold {
+					continue
+				}
+				w.found[ctx] = true
+				w.total += 1
+				task_monitor.markStuck()
+				stuck = append(stuck, StuckTask{
+					Group:     group.group_name,
+					Monitor:   name,
+					Tags:      tags,
+					Elapsed:   elapsed,
+					Threshold: threshold,
+					Ctx:       ctx})
+			}
+		})
+	})
+	for ctx := range w.found {
+		if !running[ctx] {
+			delete(w.found, ctx)
+		}
+	}
+	callbacks := make([]func(task StuckTask), len(w.callbacks))
+	copy(callbacks, w.callbacks)
+	w.mtx.Unlock()
+
+	for _, task := range stuck {
+		if goroutine := task.Ctx.Goroutine(); goroutine != 0 {
+			logger.Warnf("task %s stuck: running for %s on goroutine %d",
+				taggedName(task.Name(), task.Tags), task.Elapsed, goroutine)
+		} else {
+			logger.Warnf("task %s stuck: running for %s",
+				taggedName(task.Name(), task.Tags), task.Elapsed)
+		}
+		for _, cb := range callbacks {
+			cb(task)
+		}
+	}
+	return stuck
+}
+
+// Stats conforms to the Monitor interface. It reports how many tasks have
+// been found stuck, and how many of those are still running.
+func (w *Watchdog) Stats(cb func(name string, val float64)) {
+	w.mtx.Lock()
+	total := w.total
+	current := len(w.found)
+	w.mtx.Unlock()
+
+	cb("current", float64(current))
+	cb("stuck", float64(total))
+}
+
+// DescribedStats conforms to the DescribedMonitor interface
+func (w *Watchdog) DescribedStats(
+	cb func(name string, info StatInfo, val float64)) {
+	describeWith(w, func(name string) StatInfo {
+		if name == "current" {
+			return StatInfo{Kind: Gauge,
+				Help: "stuck tasks that are still running"}
+		}
+		return StatInfo{Kind: Counter, Help: "tasks found stuck"}
+	}, cb)
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !no_mon
+
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	store := NewMonitorStore()
+	mon := store.GetMonitorsNamed("foo")
+	watchdog := NewWatchdog(store, time.Millisecond)
+	watchdog.SetThreshold("foo.patient", time.Hour)
+	var found []StuckTask
+	watchdog.OnStuck(func(task StuckTask) { found = append(found, task) })
+
+	finish_stuck := mon.TaskNamed("stuck")
+	finish_patient := mon.TaskNamed("patient")
+	defer finish_patient(nil)
+	time.Sleep(5 * time.Millisecond)
+
+	stuck := watchdog.Check()
+	if len(stuck) != 1 || stuck[0].Name() != "foo.stuck" ||
+		stuck[0].Elapsed < time.Millisecond ||
+		stuck[0].Threshold != time.Millisecond {
+		t.Fatalf("unexpected stuck tasks: %v", stuck)
+	}
+	if len(found) != 1 || found[0].Ctx != stuck[0].Ctx {
+		t.Errorf("callback not called: %v", found)
+	}
+
+	// each stuck task is only reported once
+	if stuck := watchdog.Check(); len(stuck) != 0 {
+		t.Errorf("stuck task reported again: %v", stuck)
+	}
+	stats := Collect(watchdog)
+	if stats["stuck"] != 1 || stats["current"] != 1 || len(stats) != 2 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+	stats = Collect(mon)
+	if stats["foo.stuck.stuck"] != 1 {
+		t.Errorf("task monitor didn't count its stuck task: %v", stats)
+	}
+	if _, ok := stats["foo.patient.stuck"]; ok {
+		t.Errorf("stuck reported for a task that never was: %v", stats)
+	}
+
+	finish_stuck(nil)
+	watchdog.Check()
+	stats = Collect(watchdog)
+	if stats["stuck"] != 1 || stats["current"] != 0 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
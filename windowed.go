@@ -0,0 +1,297 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monotime"
+)
+
+const (
+	// windowBuckets is how many rolling buckets a window is split into. Stats
+	// cover the current, partially filled bucket plus all of the others, so a
+	// window is accurate to within a tenth of its length.
+	windowBuckets = 10
+)
+
+// valueAdder is implemented by ValueMonitor and WindowedValueMonitor.
+type valueAdder interface {
+	Monitor
+	Add(val float64)
+}
+
+// intValueAdder is implemented by IntValueMonitor and
+// WindowedIntValueMonitor.
+type intValueAdder interface {
+	Monitor
+	Add(val int64)
+}
+
+type windowBucket struct {
+	epoch       int64
+	count       uint64
+	sum         float64
+	sum_squared float64
+	max         float64
+	min         float64
+}
+
+// WindowedValueMonitor is like ValueMonitor, but its statistics only cover
+// values added within a recent, sliding window of time, instead of
+// everything since the process started.
+type WindowedValueMonitor struct {
+	mtx          sync.Mutex
+	bucket_width time.Duration
+	buckets      [windowBuckets]windowBucket
+	recent       float64
+	now          func() time.Duration
+	description  string
+}
+
+// NewWindowedValueMonitor creates a new WindowedValueMonitor covering the
+// last window of time. You probably want to create a new
+// WindowedValueMonitor through MonitorGroup.Val on a MonitorGroup with a
+// window set instead.
+func NewWindowedValueMonitor(window time.Duration) *WindowedValueMonitor {
+	return newWindowedValueMonitor(window, monotime.Monotonic)
+}
+
+func newWindowedValueMonitor(window time.Duration,
+	now func() time.Duration) *WindowedValueMonitor {
+	bucket_width := window / windowBuckets
+	if bucket_width <= 0 {
+		bucket_width = 1
+	}
+	return &WindowedValueMonitor{bucket_width: bucket_width, now: now}
+}
+
+// Add adds a value to the WindowedValueMonitor
+func (v *WindowedValueMonitor) Add(val float64) {
+	epoch := int64(v.now() / v.bucket_width)
+	v.mtx.Lock()
+	bucket := &v.buckets[epoch%windowBuckets]
+	if bucket.epoch != epoch || bucket.count == 0 {
+		*bucket = windowBucket{
+			epoch: epoch, max: math.Inf(-1), min: math.Inf(1)}
+	}
+	bucket.count += 1
+	bucket.sum += val
+	bucket.sum_squared += (val * val)
+	if val > bucket.max {
+		bucket.max = val
+	}
+	if val < bucket.min {
+		bucket.min = val
+	}
+	v.recent = val
+	v.mtx.Unlock()
+}
+
+// Stats conforms to the Monitor interface. avg, max and min are left out when
+// there were no values in the window.
+func (v *WindowedValueMonitor) Stats(cb func(name string, val float64)) {
+	epoch := int64(v.now() / v.bucket_width)
+	total := windowBucket{max: math.Inf(-1), min: math.Inf(1)}
+	v.mtx.Lock()
+	for _, bucket := range v.buckets {
+		if bucket.count == 0 || bucket.epoch <= epoch-windowBuckets {
+			continue
+		}
+		total.count += bucket.count
+		total.sum += bucket.sum
+		total.sum_squared += bucket.sum_squared
+		total.max = math.Max(total.max, bucket.max)
+		total.min = math.Min(total.min, bucket.min)
+	}
+	recent := v.recent
+	v.mtx.Unlock()
+
+	if total.count > 0 {
+		cb("avg", total.sum/float64(total.count))
+	}
+	cb("count", float64(total.count))
+	if total.count > 0 {
+		cb("max", total.max)
+		cb("min", total.min)
+	}
+	cb("recent", recent)
+	cb("sum", total.sum)
+	cb("sum_squared", total.sum_squared)
+}
+
+// WindowedIntValueMonitor is the WindowedValueMonitor counterpart to
+// IntValueMonitor.
+type WindowedIntValueMonitor struct {
+	values      *WindowedValueMonitor
+	description string
+}
+
+// NewWindowedIntValueMonitor creates a new WindowedIntValueMonitor covering
+// the last window of time. You probably want to create a new
+// WindowedIntValueMonitor through MonitorGroup.IntVal on a MonitorGroup with
+// a window set instead.
+func NewWindowedIntValueMonitor(window time.Duration) *WindowedIntValueMonitor {
+	return &WindowedIntValueMonitor{values: NewWindowedValueMonitor(window)}
+}
+
+// Add adds a value to the WindowedIntValueMonitor
+func (v *WindowedIntValueMonitor) Add(val int64) {
+	v.values.Add(float64(val))
+}
+
+// Stats conforms to the Monitor interface
+func (v *WindowedIntValueMonitor) Stats(cb func(name string, val float64)) {
+	v.values.Stats(cb)
+}
+
+// histogramAdder is a Histogram or a WindowedHistogram.
+type histogramAdder interface {
+	Monitor
+	Add(val float64)
+}
+
+type histogramWindow struct {
+	epoch  int64
+	counts []uint64
+	count  uint64
+	max    float64
+	min    float64
+}
+
+// WindowedHistogram is like Histogram, but its bucket counts and quantiles
+// only cover values added within a recent, sliding window of time. Its bucket
+// counts can go down as values leave the window.
+type WindowedHistogram struct {
+	hist         *Histogram
+	mtx          sync.Mutex
+	bucket_width time.Duration
+	windows      [windowBuckets]histogramWindow
+	now          func() time.Duration
+}
+
+// NewWindowedHistogram makes a WindowedHistogram with the given bucket upper
+// bounds, covering the last window of time.
+func NewWindowedHistogram(bounds []float64,
+	window time.Duration) *WindowedHistogram {
+	return newWindowedHistogram(bounds, window, monotime.Monotonic)
+}
+
+func newWindowedHistogram(bounds []float64, window time.Duration,
+	now func() time.Duration) *WindowedHistogram {
+	bucket_width := window / windowBuckets
+	if bucket_width <= 0 {
+		bucket_width = 1
+	}
+	return &WindowedHistogram{
+		hist:         NewHistogram(bounds),
+		bucket_width: bucket_width,
+		now:          now}
+}
+
+// Add counts val into its bucket
+func (h *WindowedHistogram) Add(val float64) {
+	idx := sort.SearchFloat64s(h.hist.bounds, val)
+	epoch := int64(h.now() / h.bucket_width)
+	h.mtx.Lock()
+	window := &h.windows[epoch%windowBuckets]
+	if window.epoch != epoch || window.count == 0 {
+		counts := window.counts
+		if counts == nil {
+			counts = make([]uint64, len(h.hist.counts))
+		}
+		for i := range counts {
+			counts[i] = 0
+		}
+		*window = histogramWindow{epoch: epoch, counts: counts,
+			max: math.Inf(-1), min: math.Inf(1)}
+	}
+	window.counts[idx] += 1
+	window.count += 1
+	window.max = math.Max(window.max, val)
+	window.min = math.Min(window.min, val)
+	h.mtx.Unlock()
+}
+
+// Stats conforms to the Monitor interface, reporting like a Histogram does.
+func (h *WindowedHistogram) Stats(cb func(name string, val float64)) {
+	epoch := int64(h.now() / h.bucket_width)
+	counts := make([]uint64, len(h.hist.counts))
+	var count uint64
+	max, min := math.Inf(-1), math.Inf(1)
+	h.mtx.Lock()
+	for _, window := range h.windows {
+		if window.count == 0 || window.epoch <= epoch-windowBuckets {
+			continue
+		}
+		for i, bucket_count := range window.counts {
+			counts[i] += bucket_count
+		}
+		count += window.count
+		max = math.Max(max, window.max)
+		min = math.Min(min, window.min)
+	}
+	h.mtx.Unlock()
+	h.hist.stats(counts, count, max, min, cb)
+}
+
+// NewWindowedTaskMonitor returns a new TaskMonitor whose timings (the
+// time_*_avg, min, max, and so on) only cover tasks that completed within the
+// last window of time. The task's counters still cover everything since the
+// TaskMonitor was made, and each timing additionally reports a count of tasks
+// in the window. You probably want to create a windowed TaskMonitor through
+// MonitorGroup.Task on a MonitorGroup with a window set instead.
+func NewWindowedTaskMonitor(window time.Duration) *TaskMonitor {
+	return NewWindowedTaskMonitorWithBuckets(window, nil)
+}
+
+// NewWindowedTaskMonitorWithBuckets works like NewWindowedTaskMonitor, but
+// also keeps timing histograms with the given bucket upper bounds, in
+// seconds. Like the rest of the timings, the histograms and their percentiles
+// only cover the last window of time.
+func NewWindowedTaskMonitorWithBuckets(window time.Duration,
+	bounds []float64) *TaskMonitor {
+	t := NewTaskMonitor()
+	t.success_timing = NewWindowedIntValueMonitor(window)
+	t.error_timing = NewWindowedIntValueMonitor(window)
+	t.total_timing = NewWindowedIntValueMonitor(window)
+	if len(bounds) > 0 {
+		t.success_hist = NewWindowedHistogram(bounds, window)
+		t.error_hist = NewWindowedHistogram(bounds, window)
+		t.total_hist = NewWindowedHistogram(bounds, window)
+	}
+	return t
+}
+
+// SetWindow makes monitors that this MonitorGroup creates from now on keep
+// statistics over a sliding window of time, rather than since the process
+// started. Val, IntVal and Task monitors are affected. Monitors that already
+// exist keep their current behavior. A window of zero turns windowing back
+// off.
+func (self *MonitorGroup) SetWindow(window time.Duration) {
+	self.mtx.Lock()
+	self.window = window
+	self.mtx.Unlock()
+}
+
+func (self *MonitorGroup) getWindow() time.Duration {
+	self.mtx.Lock()
+	window := self.window
+	self.mtx.Unlock()
+	return window
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2014 Space Monkey, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowedValueMonitor(t *testing.T) {
+	var now time.Duration
+	v := newWindowedValueMonitor(time.Minute, func() time.Duration {
+		return now
+	})
+
+	v.Add(100)
+	now += 30 * time.Second
+	v.Add(1)
+	v.Add(3)
+	stats := Collect(v)
+	if stats["count"] != 3 || stats["max"] != 100 || stats["min"] != 1 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	// the first value falls out of the window
+	now += 45 * time.Second
+	stats = Collect(v)
+	if stats["count"] != 2 || stats["max"] != 3 || stats["avg"] != 2 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	now += time.Hour
+	stats = Collect(v)
+	if _, ok := stats["max"]; ok || stats["count"] != 0 ||
+		stats["recent"] != 3 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+}
+
+func TestWindowedHistogram(t *testing.T) {
+	var now time.Duration
+	h := newWindowedHistogram([]float64{1, 10, 100}, time.Minute,
+		func() time.Duration { return now })
+
+	h.Add(50)
+	now += 30 * time.Second
+	h.Add(5)
+	h.Add(5)
+	stats := Collect(h)
+	if stats["count"] != 3 || stats["bucket_le_10"] != 2 ||
+		stats["bucket_le_100"] != 3 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	// the first value falls out of the window, and so does the p99
+	now += 45 * time.Second
+	stats = Collect(h)
+	if stats["count"] != 2 || stats["bucket_le_100"] != 2 ||
+		stats["p99"] > 10 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+}